@@ -1,6 +1,9 @@
 package ratelimiter
 
 import (
+	"database/sql"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/carr-o-t/ratelimiter/internal/core"
@@ -8,12 +11,100 @@ import (
 
 type Limiter = core.Limiter
 type Store = core.Store
+type BucketConfig = core.BucketConfig
+type Decision = core.Decision
 type TokenBucket = core.TokenBucket
 type Manager = core.Manager
+type ManagerOption = core.ManagerOption
+type Snapshotter = core.Snapshotter
+type StateStore = core.StateStore
+type KeyLister = core.KeyLister
+type KeyInfo = core.KeyInfo
+type KeyPage = core.KeyPage
+type KeyDenials = core.KeyDenials
+type ManagerConfig = core.ManagerConfig
+type Override = core.Override
+type AdminOptions = core.AdminOptions
+type Metrics = core.Metrics
+type Observer = core.Observer
+type NopObserver = core.NopObserver
+type AsyncObserver = core.AsyncObserver
+type DecisionEvent = core.DecisionEvent
+type CleanupEvent = core.CleanupEvent
+type FailoverEvent = core.FailoverEvent
+type ReconfigureEvent = core.ReconfigureEvent
+type FailoverNotifier = core.FailoverNotifier
+type Tracer = core.Tracer
+type Span = core.Span
+type Attribute = core.Attribute
+type ContextStore = core.ContextStore
+type MiddlewareOption = core.MiddlewareOption
+type HeaderFormat = core.HeaderFormat
+type ClientIPOptions = core.ClientIPOptions
+type MatchMode = core.MatchMode
+type AccessList = core.AccessList
+type AccessListStore = core.AccessListStore
+type BanPolicy = core.BanPolicy
+type Ban = core.Ban
+type BanStore = core.BanStore
+type Rule = core.Rule
+type RouterOptions = core.RouterOptions
+type Router = core.Router
+type RecordingTracer = core.RecordingTracer
+type RecordedSpan = core.RecordedSpan
+type RecordedEvent = core.RecordedEvent
 type MemoryStore = core.MemoryStore
+type MemoryStoreOptions = core.MemoryStoreOptions
+type EvictionPolicy = core.EvictionPolicy
+type FileStore = core.FileStore
+type FileStoreOptions = core.FileStoreOptions
+type SyncPolicy = core.SyncPolicy
+type SQLStore = core.SQLStore
+type SQLStoreOptions = core.SQLStoreOptions
+type SQLDialect = core.SQLDialect
+type PeerStore = core.PeerStore
+type PeerStoreOptions = core.PeerStoreOptions
+type GossipStore = core.GossipStore
+type GossipStoreOptions = core.GossipStoreOptions
 type RedisStore = core.RedisStore
 type RedisStoreOptions = core.RedisStoreOptions
 type RedisEvalClient = core.RedisEvalClient
+type RedisScanClient = core.RedisScanClient
+
+const (
+	EvictLeastRecentlyUsed = core.EvictLeastRecentlyUsed
+	RejectNewKeys          = core.RejectNewKeys
+)
+
+const (
+	HeadersLegacy     = core.HeadersLegacy
+	HeadersDraft      = core.HeadersDraft
+	HeadersStructured = core.HeadersStructured
+	HeadersNone       = core.HeadersNone
+)
+
+const (
+	AccessNone  = core.AccessNone
+	AccessAllow = core.AccessAllow
+	AccessDeny  = core.AccessDeny
+)
+
+const (
+	MatchFirst = core.MatchFirst
+	MatchAll   = core.MatchAll
+)
+
+const (
+	HeaderForwarded     = core.HeaderForwarded
+	HeaderXForwardedFor = core.HeaderXForwardedFor
+	HeaderXRealIP       = core.HeaderXRealIP
+)
+
+const (
+	SyncPeriodic = core.SyncPeriodic
+	SyncAlways   = core.SyncAlways
+	SyncNever    = core.SyncNever
+)
 
 func NewTokenBucket(capacity int64, refillRate int64, per ...time.Duration) (*TokenBucket, error) {
 	return core.NewTokenBucket(capacity, refillRate, per...)
@@ -23,6 +114,32 @@ func NewMemoryStore() *MemoryStore {
 	return core.NewMemoryStore()
 }
 
+func NewMemoryStoreWithOptions(opts MemoryStoreOptions) *MemoryStore {
+	return core.NewMemoryStoreWithOptions(opts)
+}
+
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	return core.OpenFileStore(dir, opts)
+}
+
+const (
+	SQLDialectPostgres = core.SQLDialectPostgres
+	SQLDialectMySQL    = core.SQLDialectMySQL
+	SQLDialectSQLite   = core.SQLDialectSQLite
+)
+
+func NewSQLStore(db *sql.DB, opts SQLStoreOptions) (*SQLStore, error) {
+	return core.NewSQLStore(db, opts)
+}
+
+func NewPeerStore(opts PeerStoreOptions) (*PeerStore, error) {
+	return core.NewPeerStore(opts)
+}
+
+func NewGossipStore(opts GossipStoreOptions) (*GossipStore, error) {
+	return core.NewGossipStore(opts)
+}
+
 func NewRedisStore(client RedisEvalClient, opts RedisStoreOptions) (*RedisStore, error) {
 	return core.NewRedisStore(client, opts)
 }
@@ -33,8 +150,9 @@ func NewManager(
 	per time.Duration,
 	bucketTTL time.Duration,
 	cleanupInterval time.Duration,
+	opts ...ManagerOption,
 ) (*Manager, error) {
-	return core.NewManager(capacity, refillRate, per, bucketTTL, cleanupInterval)
+	return core.NewManager(capacity, refillRate, per, bucketTTL, cleanupInterval, opts...)
 }
 
 func NewManagerWithStore(
@@ -44,6 +162,103 @@ func NewManagerWithStore(
 	per time.Duration,
 	bucketTTL time.Duration,
 	cleanupInterval time.Duration,
+	opts ...ManagerOption,
 ) (*Manager, error) {
-	return core.NewManagerWithStore(store, capacity, refillRate, per, bucketTTL, cleanupInterval)
+	return core.NewManagerWithStore(store, capacity, refillRate, per, bucketTTL, cleanupInterval, opts...)
+}
+
+func WithSnapshotFile(path string) ManagerOption {
+	return core.WithSnapshotFile(path)
+}
+
+func NewMetrics() *Metrics {
+	return core.NewMetrics()
+}
+
+func WithMetrics(metrics *Metrics) ManagerOption {
+	return core.WithMetrics(metrics)
+}
+
+func WithPolicyName(name string) ManagerOption {
+	return core.WithPolicyName(name)
+}
+
+func WithObserver(o Observer) ManagerOption {
+	return core.WithObserver(o)
+}
+
+func NewAsyncObserver(next Observer, buffer int) *AsyncObserver {
+	return core.NewAsyncObserver(next, buffer)
+}
+
+func WithTracer(t Tracer) ManagerOption {
+	return core.WithTracer(t)
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return core.NewRecordingTracer()
+}
+
+func WithLogger(logger *slog.Logger) ManagerOption {
+	return core.WithLogger(logger)
+}
+
+func WithDenialLogRate(perSecond int64) ManagerOption {
+	return core.WithDenialLogRate(perSecond)
+}
+
+func WithDryRun() ManagerOption {
+	return core.WithDryRun()
+}
+
+func WithShadowPolicy(name string, cfg BucketConfig) ManagerOption {
+	return core.WithShadowPolicy(name, cfg)
+}
+
+func WithShadowStore(store Store) ManagerOption {
+	return core.WithShadowStore(store)
+}
+
+func WithMiddlewareDryRun() MiddlewareOption {
+	return core.WithMiddlewareDryRun()
+}
+
+func WithHeaderFormat(format HeaderFormat) MiddlewareOption {
+	return core.WithHeaderFormat(format)
+}
+
+func WithDenialHandler(fn func(w http.ResponseWriter, r *http.Request, d Decision)) MiddlewareOption {
+	return core.WithDenialHandler(fn)
+}
+
+func WithErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) MiddlewareOption {
+	return core.WithErrorHandler(fn)
+}
+
+func WithProblemDetails() MiddlewareOption {
+	return core.WithProblemDetails()
+}
+
+func WithSkip(fn func(*http.Request) bool) MiddlewareOption {
+	return core.WithSkip(fn)
+}
+
+func RemoteIP(r *http.Request) string {
+	return core.RemoteIP(r)
+}
+
+func ClientIPKeyFunc(opts ClientIPOptions) (func(*http.Request) string, error) {
+	return core.ClientIPKeyFunc(opts)
+}
+
+func NewRouter(opts RouterOptions) (*Router, error) {
+	return core.NewRouter(opts)
+}
+
+func WithBans(policy BanPolicy) ManagerOption {
+	return core.WithBans(policy)
+}
+
+func WithDenialWindow(window time.Duration) ManagerOption {
+	return core.WithDenialWindow(window)
 }
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// AccessList identifies the list a key is on. Listed keys bypass their
+// bucket entirely.
+type AccessList int
+
+const (
+	// AccessNone means the key is on no list and is rate limited as usual.
+	AccessNone AccessList = iota
+	// AccessAllow exempts the key from rate limiting.
+	AccessAllow
+	// AccessDeny rejects every request for the key. Middleware responds
+	// with 403 Forbidden.
+	AccessDeny
+)
+
+func (l AccessList) String() string {
+	switch l {
+	case AccessNone:
+		return "none"
+	case AccessAllow:
+		return "allow"
+	case AccessDeny:
+		return "deny"
+	default:
+		return fmt.Sprintf("AccessList(%d)", int(l))
+	}
+}
+
+func parseAccessList(s string) (AccessList, error) {
+	switch s {
+	case "none":
+		return AccessNone, nil
+	case "allow":
+		return AccessAllow, nil
+	case "deny":
+		return AccessDeny, nil
+	default:
+		return AccessNone, fmt.Errorf("unknown access list %q", s)
+	}
+}
+
+// AccessListStore is implemented by stores that keep allow and deny lists.
+// Keeping the lists in the store shares them between every Manager using
+// it, e.g. all instances behind a RedisStore.
+type AccessListStore interface {
+	// AccessList returns the list key is on, AccessNone if it is unlisted.
+	AccessList(key string) (AccessList, error)
+	// SetAccessList puts key on list. AccessNone removes it from its list.
+	SetAccessList(key string, list AccessList) error
+	// AccessLists returns every listed key with its list.
+	AccessLists() (map[string]AccessList, error)
+}
+
+func (m *Manager) accessListStore() (AccessListStore, error) {
+	s, ok := m.store.(AccessListStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support access lists: %w", errors.ErrUnsupported)
+	}
+	return s, nil
+}
+
+// AccessList returns the list key is on.
+func (m *Manager) AccessList(key string) (AccessList, error) {
+	s, err := m.accessListStore()
+	if err != nil {
+		return AccessNone, err
+	}
+	return s.AccessList(key)
+}
+
+// SetAccessList allowlists or denylists key until it is set back to
+// AccessNone. The change applies to the next request for key.
+func (m *Manager) SetAccessList(key string, list AccessList) error {
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+	if list < AccessNone || list > AccessDeny {
+		return fmt.Errorf("unknown access list %d", int(list))
+	}
+	s, err := m.accessListStore()
+	if err != nil {
+		return err
+	}
+	return s.SetAccessList(key, list)
+}
+
+// AccessLists returns every listed key with its list.
+func (m *Manager) AccessLists() (map[string]AccessList, error) {
+	s, err := m.accessListStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.AccessLists()
+}
+
+func listedDecision(list AccessList) Decision {
+	return Decision{Allowed: list == AccessAllow, Access: list}
+}
+
+// checkAccess returns the list key is on. Stores without access lists have
+// no listed keys.
+func (m *Manager) checkAccess(key string) (AccessList, error) {
+	s, ok := m.store.(AccessListStore)
+	if !ok {
+		return AccessNone, nil
+	}
+	return s.AccessList(key)
+}
+
+func (s *MemoryStore) AccessList(key string) (AccessList, error) {
+	if lists := s.lists.Load(); lists != nil {
+		return (*lists)[key], nil
+	}
+	return AccessNone, nil
+}
+
+func (s *MemoryStore) SetAccessList(key string, list AccessList) error {
+	s.listsMu.Lock()
+	defer s.listsMu.Unlock()
+
+	var old map[string]AccessList
+	if p := s.lists.Load(); p != nil {
+		old = *p
+	}
+	lists := make(map[string]AccessList, len(old)+1)
+	for k, l := range old {
+		lists[k] = l
+	}
+	if list == AccessNone {
+		delete(lists, key)
+	} else {
+		lists[key] = list
+	}
+	s.lists.Store(&lists)
+	return nil
+}
+
+func (s *MemoryStore) AccessLists() (map[string]AccessList, error) {
+	lists := make(map[string]AccessList)
+	if p := s.lists.Load(); p != nil {
+		for key, list := range *p {
+			lists[key] = list
+		}
+	}
+	return lists, nil
+}
+
+func (s *MemoryStore) allowGuarded(_ context.Context, key string, cfg BucketConfig, g allowGuard) (Decision, Ban, error) {
+	if g.lists {
+		if list, _ := s.AccessList(key); list != AccessNone {
+			return listedDecision(list), Ban{}, nil
+		}
+	}
+	if g.bans == nil {
+		decision, err := s.Allow(key, cfg)
+		return decision, Ban{}, err
+	}
+	return s.allowBanned(key, cfg, g)
+}
+
+// checksAccessLists reports whether any key is listed.
+func (s *MemoryStore) checksAccessLists() bool {
+	p := s.lists.Load()
+	return p != nil && len(*p) > 0
+}
+
+// RedisStore keeps the list of a key in a string, "allow" or "deny", named
+// after the key's bucket with a ":list" suffix so that it shares the
+// bucket's hash slot. The token bucket script reads it when lists are
+// enabled, so guarded decisions take a single EVAL.
+const (
+	redisAccessListGetLua = `local v = redis.call("GET", KEYS[1])
+if v then return v end
+return ""`
+	redisAccessListSetLua = `return redis.call("SET", KEYS[1], ARGV[1])`
+)
+
+func (s *RedisStore) listKey(key string) string {
+	return s.bucketKey(key) + ":list"
+}
+
+func (s *RedisStore) allowGuarded(ctx context.Context, key string, cfg BucketConfig, g allowGuard) (Decision, Ban, error) {
+	return s.traceAllow(ctx, key, cfg, &g)
+}
+
+func (s *RedisStore) checksAccessLists() bool {
+	return s.lists
+}
+
+func (s *RedisStore) accessListsEnabled() error {
+	if !s.lists {
+		return fmt.Errorf("redis store access lists are disabled: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+func (s *RedisStore) AccessList(key string) (AccessList, error) {
+	if err := s.accessListsEnabled(); err != nil {
+		return AccessNone, err
+	}
+	return s.accessList(context.Background(), s.listKey(key))
+}
+
+// accessList reads the list stored at the Redis key listKey.
+func (s *RedisStore) accessList(ctx context.Context, listKey string) (AccessList, error) {
+	result, err := s.client.Eval(ctx, redisAccessListGetLua, []string{listKey})
+	if err != nil {
+		return AccessNone, err
+	}
+	v, ok := result.(string)
+	if !ok {
+		return AccessNone, fmt.Errorf("unexpected redis lua result: %T", result)
+	}
+	if v == "" {
+		return AccessNone, nil
+	}
+	return parseAccessList(v)
+}
+
+func (s *RedisStore) SetAccessList(key string, list AccessList) error {
+	if err := s.accessListsEnabled(); err != nil {
+		return err
+	}
+	var err error
+	if list == AccessNone {
+		_, err = s.client.Eval(context.Background(), redisDeleteLua, []string{s.listKey(key)})
+	} else {
+		_, err = s.client.Eval(context.Background(), redisAccessListSetLua, []string{s.listKey(key)}, list.String())
+	}
+	return err
+}
+
+// AccessLists scans for lists, so the client must implement
+// RedisScanClient.
+func (s *RedisStore) AccessLists() (map[string]AccessList, error) {
+	if err := s.accessListsEnabled(); err != nil {
+		return nil, err
+	}
+	lists := make(map[string]AccessList)
+	err := s.scanSuffix(":list", func(key, listKey string) error {
+		list, err := s.accessList(context.Background(), listKey)
+		if err == nil && list != AccessNone {
+			lists[key] = list
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
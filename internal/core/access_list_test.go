@@ -0,0 +1,258 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerAccessLists(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) Store{
+		"memory": func(*testing.T) Store { return NewMemoryStore() },
+		"redis": func(t *testing.T) Store {
+			s, err := NewRedisStore(newFakeRedisEvalClient(), RedisStoreOptions{AccessLists: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return s
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManagerWithStore(newStore(t), 1, 1, time.Hour, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer m.Close()
+
+			if err := m.SetAccessList("trusted", AccessAllow); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := m.SetAccessList("abuser", AccessDeny); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for range 3 {
+				d, err := m.AllowDecision("trusted")
+				if err != nil || !d.Allowed || d.Access != AccessAllow {
+					t.Fatalf("expected allowlisted key to always pass, got %+v, %v", d, err)
+				}
+			}
+			d, err := m.AllowDecision("abuser")
+			if err != nil || d.Allowed || d.Access != AccessDeny {
+				t.Fatalf("expected denylisted key to be denied, got %+v, %v", d, err)
+			}
+
+			lists, err := m.AccessLists()
+			if err != nil || len(lists) != 2 || lists["trusted"] != AccessAllow || lists["abuser"] != AccessDeny {
+				t.Fatalf("unexpected lists %v, %v", lists, err)
+			}
+
+			if err := m.SetAccessList("abuser", AccessNone); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if list, _ := m.AccessList("abuser"); list != AccessNone {
+				t.Fatalf("expected key to be unlisted, got %v", list)
+			}
+			if d, _ := m.AllowDecision("abuser"); !d.Allowed || d.Access != AccessNone {
+				t.Fatalf("expected unlisted key to be rate limited again, got %+v", d)
+			}
+		})
+	}
+}
+
+func newRedisListsManagerForTest(t *testing.T, client RedisEvalClient, opts ...ManagerOption) *Manager {
+	t.Helper()
+	s, err := NewRedisStore(client, RedisStoreOptions{KeyPrefix: "test:", AccessLists: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(s, 1, 1, time.Hour, time.Minute, time.Hour, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return m
+}
+
+func TestRedisStoreAccessListsAreShared(t *testing.T) {
+	client := newFakeRedisEvalClient()
+	m1 := newRedisListsManagerForTest(t, client)
+	defer m1.Close()
+	m2 := newRedisListsManagerForTest(t, client)
+	defer m2.Close()
+
+	if err := m1.SetAccessList("abuser", AccessDeny); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m2.Allow("abuser") {
+		t.Fatal("expected a denylist entry to apply to every instance")
+	}
+	if keys := collectKeys(t, m1.store.(KeyLister), 10); len(keys) != 0 {
+		t.Fatalf("expected the lists not to show up as keys, got %v", keys)
+	}
+}
+
+func TestRedisStoreChecksAccessListsInTheBucketCall(t *testing.T) {
+	// The cluster client fails EVALs whose keys are in different slots.
+	client := newFakeRedisClusterClient()
+	m := newRedisListsManagerForTest(t, client, WithBans(testBanPolicy))
+	defer m.Close()
+	_ = m.SetAccessList("abuser", AccessDeny)
+
+	for _, key := range []string{"abuser", "user", "user", "{user}"} {
+		before := client.evals
+		if _, err := m.AllowDecision(key); err != nil {
+			t.Fatalf("%s: unexpected error: %v", key, err)
+		}
+		if n := client.evals - before; n != 1 {
+			t.Fatalf("%s: expected a single EVAL per decision, got %d", key, n)
+		}
+	}
+	if d, _ := m.AllowDecision("abuser"); d.Access != AccessDeny {
+		t.Fatalf("expected the denylist entry to apply, got %+v", d)
+	}
+}
+
+func TestRedisStoreAccessListsAreOptIn(t *testing.T) {
+	client := newFakeRedisEvalClient()
+	m := newRedisBackedManagerForTest(t, client, 1, 1, time.Hour)
+	defer m.Close()
+
+	if err := m.SetAccessList("abuser", AccessDeny); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	m.Allow("user")
+	if len(client.lastKeys) != 1 {
+		t.Fatalf("expected a plain bucket call without lists or bans, got keys %v", client.lastKeys)
+	}
+}
+
+func TestManagerReportsListedDecisions(t *testing.T) {
+	metrics := NewMetrics()
+	obs := &recordingObserver{}
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithMetrics(metrics), WithObserver(obs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	_ = m.SetAccessList("abuser", AccessDeny)
+	_ = m.SetAccessList("trusted", AccessAllow)
+
+	m.Allow("abuser")
+	m.Allow("trusted")
+
+	var b strings.Builder
+	_ = metrics.Write(&b)
+	for _, want := range []string{
+		`ratelimiter_decisions_total{policy="default",result="denylisted"} 1`,
+		`ratelimiter_decisions_total{policy="default",result="allowed"} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, b.String())
+		}
+	}
+	if len(obs.decisions) != 2 || obs.decisions[0].Decision.Access != AccessDeny || obs.decisions[1].Decision.Access != AccessAllow {
+		t.Fatalf("expected observers to see listed decisions, got %+v", obs.decisions)
+	}
+	if top := m.TopDenied(10); len(top) != 1 || top[0].Key != "abuser" {
+		t.Fatalf("expected the denylisted key to count as denied, got %+v", top)
+	}
+}
+
+func TestManagerAccessListsUnsupportedStore(t *testing.T) {
+	m, err := NewManagerWithStore(allowOnlyStore{}, 1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if err := m.SetAccessList("k", AccessDeny); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if !m.Allow("k") {
+		t.Fatal("expected keys to be limited as usual without list support")
+	}
+	if err := m.SetAccessList("", AccessDeny); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+}
+
+func TestMiddlewareAccessListsAndSkip(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	_ = m.SetAccessList("192.0.2.1", AccessAllow)
+	_ = m.SetAccessList("192.0.2.2", AccessDeny)
+
+	handler := m.Middleware(RemoteIP, WithSkip(func(r *http.Request) bool {
+		return r.URL.Path == "/healthz"
+	}))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func(target, remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for range 3 {
+		rec := serve("/", "192.0.2.1:1")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("expected allowlisted client to pass without headers, got %d %v", rec.Code, rec.Header())
+		}
+		if rec := serve("/healthz", "192.0.2.3:1"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("expected skipped request to pass without headers, got %d %v", rec.Code, rec.Header())
+		}
+	}
+	if rec := serve("/", "192.0.2.3:1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected skipped requests not to consume tokens, got %d", rec.Code)
+	}
+
+	rec := serve("/", "192.0.2.2:1")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "access denied") {
+		t.Fatalf("expected 403 for denylisted client, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected no Retry-After for a denylisted client, got %v", rec.Header())
+	}
+}
+
+func TestAdminHandlerAccessLists(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	srv := newAdminTestServer(t, m)
+
+	if code := adminRequest(t, srv, http.MethodPut, "/lists/10.0.0.1", `{"list":"deny"}`, nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := adminRequest(t, srv, http.MethodPut, "/lists/10.0.0.2", `{"list":"maybe"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown list, got %d", code)
+	}
+	if m.Allow("10.0.0.1") {
+		t.Fatal("expected the denylist entry to apply")
+	}
+
+	var lists struct {
+		Keys []adminListedKey `json:"keys"`
+	}
+	if code := adminRequest(t, srv, http.MethodGet, "/lists", "", &lists); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(lists.Keys) != 1 || lists.Keys[0] != (adminListedKey{Key: "10.0.0.1", List: "deny"}) {
+		t.Fatalf("unexpected lists %+v", lists)
+	}
+
+	if code := adminRequest(t, srv, http.MethodDelete, "/lists/10.0.0.1", "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if !m.Allow("10.0.0.1") {
+		t.Fatal("expected the key to be limited as usual after removal")
+	}
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAdminPageSize = 100
+	maxAdminPageSize     = 1000
+	adminTopDenied       = 10
+)
+
+// AdminOptions configures Manager.AdminHandler.
+type AdminOptions struct {
+	// Authorize decides whether a request may use the admin API. A nil
+	// Authorize rejects every request, so the handler is never exposed by
+	// accident.
+	Authorize func(*http.Request) bool
+}
+
+type adminDecision struct {
+	Allowed      bool  `json:"allowed"`
+	Remaining    int64 `json:"remaining"`
+	Limit        int64 `json:"limit"`
+	RetryAfterMs int64 `json:"retry_after_ms"`
+}
+
+type adminKey struct {
+	Key      string        `json:"key"`
+	Decision adminDecision `json:"decision"`
+}
+
+type adminBucketConfig struct {
+	Capacity   int64 `json:"capacity"`
+	RefillRate int64 `json:"refill_rate"`
+	IntervalMs int64 `json:"interval_ms"`
+}
+
+type adminOverride struct {
+	Key       string            `json:"key"`
+	Config    adminBucketConfig `json:"config"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type adminOverrideRequest struct {
+	adminBucketConfig
+	TTLMs int64 `json:"ttl_ms"`
+}
+
+type adminListedKey struct {
+	Key  string `json:"key"`
+	List string `json:"list"`
+}
+
+type adminBan struct {
+	Key   string    `json:"key"`
+	Level int       `json:"level"`
+	Until time.Time `json:"until"`
+}
+
+type adminConfig struct {
+	Policy            string `json:"policy"`
+	Capacity          int64  `json:"capacity"`
+	RefillRate        int64  `json:"refill_rate"`
+	IntervalMs        int64  `json:"interval_ms"`
+	BucketTTLMs       int64  `json:"bucket_ttl_ms"`
+	CleanupIntervalMs int64  `json:"cleanup_interval_ms"`
+}
+
+type adminStats struct {
+	Keys      *int64       `json:"keys,omitempty"`
+	Throttled *int64       `json:"throttled,omitempty"`
+	CountedAt *time.Time   `json:"counted_at,omitempty"`
+	TopDenied []KeyDenials `json:"top_denied"`
+}
+
+func toAdminDecision(d Decision) adminDecision {
+	return adminDecision{
+		Allowed:      d.Allowed,
+		Remaining:    d.Remaining,
+		Limit:        d.Limit,
+		RetryAfterMs: d.RetryAfter.Milliseconds(),
+	}
+}
+
+func toAdminBucketConfig(cfg BucketConfig) adminBucketConfig {
+	return adminBucketConfig{
+		Capacity:   cfg.Capacity,
+		RefillRate: cfg.RefillRate,
+		IntervalMs: cfg.Interval.Milliseconds(),
+	}
+}
+
+// AdminHandler returns an http.Handler exposing JSON endpoints to inspect
+// and manage the Manager's buckets. Paths are relative to the handler, so
+// mount it with http.StripPrefix:
+//
+//	GET    /keys?cursor=&limit=   list tracked keys with their state
+//	GET    /keys/{key}            state of one key
+//	POST   /reset/{key}           refill a key's bucket
+//	GET    /overrides             active limit overrides
+//	PUT    /overrides/{key}       override a key's limit for ttl_ms
+//	DELETE /overrides/{key}       remove an override
+//	GET    /lists                 allowlisted and denylisted keys
+//	PUT    /lists/{key}           put a key on the "allow" or "deny" list
+//	DELETE /lists/{key}           remove a key from its list
+//	GET    /bans                  keys banned by WithBans
+//	DELETE /bans/{key}            lift a ban and reset its escalation
+//	GET    /config                the Manager's configuration
+//	GET    /stats                 key count and throttled keys as of the
+//	                              last cleanup pass, top denied keys
+func (m *Manager) AdminHandler(opts AdminOptions) http.Handler {
+	m.keyStats.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys", m.adminListKeys)
+	mux.HandleFunc("GET /keys/{key...}", m.adminGetKey)
+	mux.HandleFunc("POST /reset/{key...}", m.adminReset)
+	mux.HandleFunc("GET /overrides", m.adminListOverrides)
+	mux.HandleFunc("PUT /overrides/{key...}", m.adminSetOverride)
+	mux.HandleFunc("DELETE /overrides/{key...}", m.adminClearOverride)
+	mux.HandleFunc("GET /lists", m.adminListAccessLists)
+	mux.HandleFunc("PUT /lists/{key...}", m.adminSetAccessList)
+	mux.HandleFunc("DELETE /lists/{key...}", m.adminClearAccessList)
+	mux.HandleFunc("GET /bans", m.adminListBans)
+	mux.HandleFunc("DELETE /bans/{key...}", m.adminUnban)
+	mux.HandleFunc("GET /config", m.adminConfig)
+	mux.HandleFunc("GET /stats", m.adminStats)
+
+	authorize := opts.Authorize
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			writeAdminError(w, http.StatusForbidden, errors.New("forbidden"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (m *Manager) adminListKeys(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAdminPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAdminError(w, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+			return
+		}
+		limit = min(n, maxAdminPageSize)
+	}
+
+	page, err := m.Keys(r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+
+	keys := make([]adminKey, 0, len(page.Keys))
+	for _, info := range page.Keys {
+		keys = append(keys, adminKey{Key: info.Key, Decision: toAdminDecision(info.Decision)})
+	}
+	writeAdminJSON(w, http.StatusOK, struct {
+		Keys []adminKey `json:"keys"`
+		Next string     `json:"next,omitempty"`
+	}{keys, page.Next})
+}
+
+func (m *Manager) adminGetKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	decision, err := m.Peek(key)
+	if err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, adminKey{Key: key, Decision: toAdminDecision(decision)})
+}
+
+func (m *Manager) adminReset(w http.ResponseWriter, r *http.Request) {
+	if err := m.Reset(r.PathValue("key")); err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) adminListOverrides(w http.ResponseWriter, _ *http.Request) {
+	overrides := m.Overrides()
+	out := make([]adminOverride, 0, len(overrides))
+	for _, o := range overrides {
+		out = append(out, adminOverride{Key: o.Key, Config: toAdminBucketConfig(o.Config), ExpiresAt: o.ExpiresAt})
+	}
+	writeAdminJSON(w, http.StatusOK, struct {
+		Overrides []adminOverride `json:"overrides"`
+	}{out})
+}
+
+func (m *Manager) adminSetOverride(w http.ResponseWriter, r *http.Request) {
+	var req adminOverrideRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.New("invalid JSON body"))
+		return
+	}
+
+	key := r.PathValue("key")
+	cfg := BucketConfig{
+		Capacity:   req.Capacity,
+		RefillRate: req.RefillRate,
+		Interval:   time.Duration(req.IntervalMs) * time.Millisecond,
+	}
+	if err := m.SetOverride(key, cfg, time.Duration(req.TTLMs)*time.Millisecond); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, o := range m.Overrides() {
+		if o.Key == key {
+			writeAdminJSON(w, http.StatusOK, adminOverride{Key: o.Key, Config: toAdminBucketConfig(o.Config), ExpiresAt: o.ExpiresAt})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) adminClearOverride(w http.ResponseWriter, r *http.Request) {
+	m.ClearOverride(r.PathValue("key"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) adminListAccessLists(w http.ResponseWriter, _ *http.Request) {
+	lists, err := m.AccessLists()
+	if err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	out := make([]adminListedKey, 0, len(lists))
+	for key, list := range lists {
+		out = append(out, adminListedKey{Key: key, List: list.String()})
+	}
+	slices.SortFunc(out, func(a, b adminListedKey) int { return strings.Compare(a.Key, b.Key) })
+	writeAdminJSON(w, http.StatusOK, struct {
+		Keys []adminListedKey `json:"keys"`
+	}{out})
+}
+
+func (m *Manager) adminSetAccessList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		List string `json:"list"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.New("invalid JSON body"))
+		return
+	}
+	list, err := parseAccessList(req.List)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key := r.PathValue("key")
+	if err := m.SetAccessList(key, list); err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, adminListedKey{Key: key, List: list.String()})
+}
+
+func (m *Manager) adminClearAccessList(w http.ResponseWriter, r *http.Request) {
+	if err := m.SetAccessList(r.PathValue("key"), AccessNone); err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) adminListBans(w http.ResponseWriter, _ *http.Request) {
+	bans, err := m.Bans()
+	if err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	out := make([]adminBan, 0, len(bans))
+	for _, b := range bans {
+		out = append(out, adminBan{Key: b.Key, Level: b.Level, Until: b.Until})
+	}
+	writeAdminJSON(w, http.StatusOK, struct {
+		Bans []adminBan `json:"bans"`
+	}{out})
+}
+
+func (m *Manager) adminUnban(w http.ResponseWriter, r *http.Request) {
+	if err := m.Unban(r.PathValue("key")); err != nil {
+		writeAdminStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) adminConfig(w http.ResponseWriter, _ *http.Request) {
+	cfg := m.Config()
+	writeAdminJSON(w, http.StatusOK, adminConfig{
+		Policy:            cfg.Policy,
+		Capacity:          cfg.Capacity,
+		RefillRate:        cfg.RefillRate,
+		IntervalMs:        cfg.Interval.Milliseconds(),
+		BucketTTLMs:       cfg.BucketTTL.Milliseconds(),
+		CleanupIntervalMs: cfg.CleanupInterval.Milliseconds(),
+	})
+}
+
+// adminStats serves the key counts cached by the last cleanup pass, so the
+// endpoint never walks the key space itself. Counts the store cannot provide,
+// or that no cleanup pass has taken yet, are omitted.
+func (m *Manager) adminStats(w http.ResponseWriter, _ *http.Request) {
+	stats := adminStats{TopDenied: m.TopDenied(adminTopDenied)}
+	if n := m.trackedKeys.Load(); n >= 0 {
+		stats.Keys = &n
+	}
+	if n := m.throttledKeys.Load(); n >= 0 {
+		stats.Throttled = &n
+	}
+	if ns := m.keysCountedAt.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		stats.CountedAt = &t
+	}
+	writeAdminJSON(w, http.StatusOK, stats)
+}
+
+func writeAdminStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errors.ErrUnsupported) {
+		writeAdminError(w, http.StatusNotImplemented, err)
+		return
+	}
+	writeAdminError(w, http.StatusInternalServerError, err)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAdminTestServer(t *testing.T, m *Manager) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/ratelimit/admin/", http.StripPrefix("/ratelimit/admin", m.AdminHandler(AdminOptions{
+		Authorize: func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer secret" },
+	})))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func adminRequest(t *testing.T, srv *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+"/ratelimit/admin"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("failed to decode %s %s response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminHandlerRequiresAuthorization(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	rec := httptest.NewRecorder()
+	m.AdminHandler(AdminOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected nil Authorize to deny, got %d", rec.Code)
+	}
+
+	srv := newAdminTestServer(t, m)
+	resp, err := srv.Client().Get(srv.URL + "/ratelimit/admin/config")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected unauthorized request to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminHandlerKeysAndReset(t *testing.T) {
+	m, err := NewManager(2, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	srv := newAdminTestServer(t, m)
+
+	m.Allow("10.0.0.1")
+	m.Allow("user/42")
+	m.Allow("user/42")
+
+	var page struct {
+		Keys []adminKey `json:"keys"`
+		Next string     `json:"next"`
+	}
+	if code := adminRequest(t, srv, http.MethodGet, "/keys?limit=1", "", &page); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(page.Keys) != 1 || page.Next == "" {
+		t.Fatalf("expected one key and a next cursor, got %+v", page)
+	}
+
+	var key adminKey
+	if code := adminRequest(t, srv, http.MethodGet, "/keys/user/42", "", &key); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if key.Key != "user/42" || key.Decision.Allowed || key.Decision.RetryAfterMs <= 0 {
+		t.Fatalf("expected throttled state for user/42, got %+v", key)
+	}
+
+	var stats adminStats
+	if code := adminRequest(t, srv, http.MethodGet, "/stats", "", &stats); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if stats.Keys != nil || stats.Throttled != nil {
+		t.Fatalf("expected no counts before the first cleanup pass, got %+v", stats)
+	}
+	m.Cleanup()
+	if code := adminRequest(t, srv, http.MethodGet, "/stats", "", &stats); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if stats.Keys == nil || *stats.Keys != 2 || stats.Throttled == nil || *stats.Throttled != 1 || stats.CountedAt == nil {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if code := adminRequest(t, srv, http.MethodPost, "/reset/user/42", "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if d, _ := m.Peek("user/42"); d.Remaining != 2 {
+		t.Fatalf("expected reset bucket to be full, got %+v", d)
+	}
+}
+
+func TestAdminHandlerOverridesAndConfig(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	srv := newAdminTestServer(t, m)
+
+	var cfg adminConfig
+	if code := adminRequest(t, srv, http.MethodGet, "/config", "", &cfg); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if cfg.Capacity != 1 || cfg.IntervalMs != time.Hour.Milliseconds() || cfg.BucketTTLMs != time.Minute.Milliseconds() {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	var o adminOverride
+	body := `{"capacity":3,"refill_rate":1,"interval_ms":1000,"ttl_ms":60000}`
+	if code := adminRequest(t, srv, http.MethodPut, "/overrides/vip", body, &o); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if o.Key != "vip" || o.Config.Capacity != 3 || o.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected override %+v", o)
+	}
+	if d, _ := m.AllowDecision("vip"); d.Limit != 3 {
+		t.Fatalf("expected override to apply, got %+v", d)
+	}
+
+	var list struct {
+		Overrides []adminOverride `json:"overrides"`
+	}
+	adminRequest(t, srv, http.MethodGet, "/overrides", "", &list)
+	if len(list.Overrides) != 1 {
+		t.Fatalf("expected one override, got %+v", list)
+	}
+
+	var errBody struct {
+		Error string `json:"error"`
+	}
+	if code := adminRequest(t, srv, http.MethodPut, "/overrides/vip", `{"capacity":0}`, &errBody); code != http.StatusBadRequest || errBody.Error == "" {
+		t.Fatalf("expected 400 with error for invalid override, got %d %+v", code, errBody)
+	}
+
+	if code := adminRequest(t, srv, http.MethodDelete, "/overrides/vip", "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if len(m.Overrides()) != 0 {
+		t.Fatal("expected override to be removed")
+	}
+}
+
+// allowOnlyStore implements only the base Store interface.
+type allowOnlyStore struct{}
+
+func (allowOnlyStore) Allow(string, BucketConfig) (Decision, error) {
+	return Decision{Allowed: true}, nil
+}
+func (allowOnlyStore) DeleteInactiveBuckets(time.Time) error { return nil }
+func (allowOnlyStore) Close() error                          { return nil }
+
+func TestAdminHandlerUnsupportedStore(t *testing.T) {
+	m, err := NewManagerWithStore(allowOnlyStore{}, 1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	srv := newAdminTestServer(t, m)
+
+	if code := adminRequest(t, srv, http.MethodGet, "/keys", "", nil); code != http.StatusNotImplemented {
+		t.Fatalf("expected 501 for store without key listing, got %d", code)
+	}
+	var stats adminStats
+	if code := adminRequest(t, srv, http.MethodGet, "/stats", "", &stats); code != http.StatusOK || stats.Keys != nil {
+		t.Fatalf("expected stats without key count, got %d %+v", code, stats)
+	}
+}
@@ -0,0 +1,434 @@
+package core
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBanMultiplier  = 2
+	defaultBanMaxDuration = 24 * time.Hour
+)
+
+// BanPolicy configures temporary bans for keys that keep getting denied.
+// A banned key is denied outright, however many tokens its bucket has.
+type BanPolicy struct {
+	// Threshold is the number of denials within Window a key may have; the
+	// next one bans it.
+	Threshold int
+	Window    time.Duration
+	// Duration is the length of a key's first ban. Each further ban is
+	// Multiplier times longer, up to MaxDuration. Multiplier defaults to 2
+	// and MaxDuration to 24h.
+	Duration    time.Duration
+	Multiplier  float64
+	MaxDuration time.Duration
+	// Decay is how long a key has to stay unbanned before its next ban
+	// starts again at Duration. Defaults to MaxDuration.
+	Decay time.Duration
+}
+
+// Ban is the ban state of a key.
+type Ban struct {
+	Key string
+	// Level counts the key's consecutive bans, starting at 1.
+	Level int
+	Until time.Time
+}
+
+// BanStore is implemented by stores that can keep ban state, so that every
+// Manager sharing the store enforces the same bans.
+type BanStore interface {
+	// RecordDenial counts a denial for key and bans it once policy's
+	// threshold is exceeded. It returns the key's ban, which is zero unless
+	// the key is banned.
+	RecordDenial(key string, policy BanPolicy) (Ban, error)
+	// Ban returns the active ban for key, or a zero Ban.
+	Ban(key string) (Ban, error)
+	// Unban lifts key's ban and forgets its denials and ban level.
+	Unban(key string) error
+	// Bans returns every active ban.
+	Bans() ([]Ban, error)
+}
+
+// WithBans bans keys denied more than policy.Threshold times within
+// policy.Window, for escalating durations. Ban state is kept in the store, which must
+// implement BanStore. Denials in dry-run mode are not counted.
+func WithBans(policy BanPolicy) ManagerOption {
+	return func(m *Manager) {
+		m.bans = &policy
+	}
+}
+
+// normalize validates p and fills in its defaults.
+func (p *BanPolicy) normalize() error {
+	if p.Threshold <= 0 {
+		return errors.New("ban threshold must be greater than 0")
+	}
+	if p.Window <= 0 {
+		return errors.New("ban window must be greater than 0")
+	}
+	if p.Duration <= 0 {
+		return errors.New("ban duration must be greater than 0")
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaultBanMultiplier
+	}
+	if p.Multiplier < 1 {
+		return errors.New("ban multiplier must be at least 1")
+	}
+	if p.MaxDuration == 0 {
+		p.MaxDuration = max(defaultBanMaxDuration, p.Duration)
+	}
+	if p.MaxDuration < p.Duration {
+		return errors.New("ban max duration must be at least the ban duration")
+	}
+	if p.Decay <= 0 {
+		p.Decay = p.MaxDuration
+	}
+	return nil
+}
+
+// duration returns the length of a ban at level.
+func (p BanPolicy) duration(level int) time.Duration {
+	d := float64(p.Duration) * math.Pow(p.Multiplier, float64(level-1))
+	if d >= float64(p.MaxDuration) {
+		return p.MaxDuration
+	}
+	return time.Duration(d)
+}
+
+func (m *Manager) initBans() error {
+	if err := m.bans.normalize(); err != nil {
+		return err
+	}
+	if _, err := m.banStore(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) banStore() (BanStore, error) {
+	s, ok := m.store.(BanStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support bans: %w", errors.ErrUnsupported)
+	}
+	return s, nil
+}
+
+// Ban returns the active ban for key, or a zero Ban.
+func (m *Manager) Ban(key string) (Ban, error) {
+	s, err := m.banStore()
+	if err != nil {
+		return Ban{}, err
+	}
+	return s.Ban(key)
+}
+
+// Unban lifts key's ban and resets its escalation.
+func (m *Manager) Unban(key string) error {
+	s, err := m.banStore()
+	if err != nil {
+		return err
+	}
+	return s.Unban(key)
+}
+
+// Bans returns every active ban, sorted by key.
+func (m *Manager) Bans() ([]Ban, error) {
+	s, err := m.banStore()
+	if err != nil {
+		return nil, err
+	}
+	bans, err := s.Bans()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(bans, func(a, b Ban) int { return strings.Compare(a.Key, b.Key) })
+	return bans, nil
+}
+
+// checkBan returns the denial for key if it is banned. initBans made sure
+// the store is a BanStore.
+func (m *Manager) checkBan(key string) (Decision, bool, error) {
+	ban, err := m.store.(BanStore).Ban(key)
+	if err != nil || ban.Until.IsZero() {
+		return Decision{}, false, err
+	}
+	return bannedDecision(ban, m.configFor(key), time.Now()), true, nil
+}
+
+// recordDenial counts a denial towards key's ban and adds a ban it caused
+// to decision. Failures are logged: the request is denied either way.
+func (m *Manager) recordDenial(key string, decision Decision) Decision {
+	ban, err := m.store.(BanStore).RecordDenial(key, *m.bans)
+	if err != nil {
+		m.log.storeError(key, err)
+		return decision
+	}
+	if ban.Until.IsZero() {
+		return decision
+	}
+	m.log.ban(ban)
+	return deniedIntoBan(decision, ban, m.configFor(key), time.Now())
+}
+
+// bannedDecision denies a request of a key banned as of now.
+func bannedDecision(ban Ban, cfg BucketConfig, now time.Time) Decision {
+	return Decision{
+		Limit:       cfg.Capacity,
+		RetryAfter:  max(ban.Until.Sub(now), 0),
+		BannedUntil: ban.Until,
+	}
+}
+
+// deniedIntoBan turns the bucket's denial into the ban it caused.
+func deniedIntoBan(decision Decision, ban Ban, cfg BucketConfig, now time.Time) Decision {
+	banned := bannedDecision(ban, cfg, now)
+	banned.RetryAfter = max(banned.RetryAfter, decision.RetryAfter)
+	return banned
+}
+
+type memoryBan struct {
+	denials     int
+	windowStart time.Time
+	level       int
+	until       time.Time
+	// expires is when the state can be forgotten: the window has passed
+	// and the ban level has decayed.
+	expires time.Time
+}
+
+// MemoryStore keeps ban state in the shard of its key, under the same lock
+// as the key's bucket.
+func (s *MemoryStore) RecordDenial(key string, policy BanPolicy) (Ban, error) {
+	now := s.now()
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.recordDenial(key, policy, now), nil
+}
+
+// recordDenial is RecordDenial. The caller must hold sh.mu.
+func (sh *memoryShard) recordDenial(key string, policy BanPolicy, now time.Time) Ban {
+	b := sh.bans[key]
+	if b == nil || !now.Before(b.expires) {
+		b = &memoryBan{windowStart: now}
+		if sh.bans == nil {
+			sh.bans = make(map[string]*memoryBan)
+		}
+		sh.bans[key] = b
+	}
+	if now.Before(b.until) {
+		return Ban{Key: key, Level: b.level, Until: b.until}
+	}
+	if b.level > 0 && now.Sub(b.until) >= policy.Decay {
+		b.level = 0
+	}
+	if now.Sub(b.windowStart) >= policy.Window {
+		b.denials, b.windowStart = 0, now
+	}
+
+	b.denials++
+	if b.denials > policy.Threshold {
+		b.level++
+		b.until = now.Add(policy.duration(b.level))
+		b.denials, b.windowStart = 0, now
+	}
+	b.expires = b.windowStart.Add(policy.Window)
+	if b.level > 0 {
+		b.expires = maxTime(b.expires, b.until.Add(policy.Decay))
+	}
+	if !now.Before(b.until) {
+		return Ban{}
+	}
+	return Ban{Key: key, Level: b.level, Until: b.until}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (s *MemoryStore) Ban(key string) (Ban, error) {
+	now := s.now()
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.ban(key, now), nil
+}
+
+// ban is Ban. The caller must hold sh.mu.
+func (sh *memoryShard) ban(key string, now time.Time) Ban {
+	b := sh.bans[key]
+	if b == nil || !now.Before(b.until) {
+		return Ban{}
+	}
+	return Ban{Key: key, Level: b.level, Until: b.until}
+}
+
+func (s *MemoryStore) Unban(key string) error {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.bans, key)
+	return nil
+}
+
+func (s *MemoryStore) Bans() ([]Ban, error) {
+	now := s.now()
+	var bans []Ban
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for key, b := range sh.bans {
+			if now.Before(b.until) {
+				bans = append(bans, Ban{Key: key, Level: b.level, Until: b.until})
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return bans, nil
+}
+
+// allowBanned is a guarded Allow with bans: banned keys are denied without
+// their bucket, and a denial counts towards a ban if g.recordDenials is
+// set. The shard lock is held throughout, so concurrent requests for a key
+// see each other's denials and the ban they cause.
+func (s *MemoryStore) allowBanned(key string, cfg BucketConfig, g allowGuard) (Decision, Ban, error) {
+	now := s.now()
+	nowNanos := int64(now.Sub(s.epoch))
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if ban := sh.ban(key, now); !ban.Until.IsZero() {
+		return bannedDecision(ban, cfg, now), Ban{}, nil
+	}
+	e, err := s.lockedEntry(sh, key, cfg, nowNanos)
+	if err != nil {
+		return Decision{}, Ban{}, err
+	}
+	decision := s.allowEntry(e, cfg, nowNanos)
+	if decision.Allowed || !g.recordDenials {
+		return decision, Ban{}, nil
+	}
+	ban := sh.recordDenial(key, *g.bans, now)
+	if ban.Until.IsZero() {
+		return decision, Ban{}, nil
+	}
+	return deniedIntoBan(decision, ban, cfg, now), ban, nil
+}
+
+// pruneBans forgets ban state that has expired.
+func (s *MemoryStore) pruneBans() {
+	now := s.now()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for key, b := range sh.bans {
+			if !now.Before(b.expires) {
+				delete(sh.bans, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// redisRecordDenialLua defines the record_denial Lua function, shared by
+// RecordDenial and the token bucket script.
+//
+//go:embed redis_ban_script.lua
+var redisRecordDenialLua string
+
+var redisBanLua = redisRecordDenialLua + `
+return record_denial(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]),
+  tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6]), tonumber(ARGV[7]))
+`
+
+const redisBanGetLua = `return redis.call("HMGET", KEYS[1], "level", "banned_until_ms")`
+
+// RedisStore keeps the ban state of a key in a hash named after the key's
+// bucket with a ":ban" suffix, so that it shares the bucket's hash slot. It
+// expires once the state can be forgotten.
+func (s *RedisStore) banKey(key string) string {
+	return s.bucketKey(key) + ":ban"
+}
+
+func (s *RedisStore) RecordDenial(key string, policy BanPolicy) (Ban, error) {
+	nowMs := s.now().UnixMilli()
+	result, err := s.client.Eval(context.Background(), redisBanLua, []string{s.banKey(key)},
+		nowMs,
+		policy.Threshold,
+		policy.Window.Milliseconds(),
+		policy.Duration.Milliseconds(),
+		policy.Multiplier,
+		policy.MaxDuration.Milliseconds(),
+		policy.Decay.Milliseconds(),
+	)
+	if err != nil {
+		return Ban{}, err
+	}
+	return parseRedisBan(key, result, nowMs)
+}
+
+func (s *RedisStore) Ban(key string) (Ban, error) {
+	result, err := s.client.Eval(context.Background(), redisBanGetLua, []string{s.banKey(key)})
+	if err != nil {
+		return Ban{}, err
+	}
+	return parseRedisBan(key, result, s.now().UnixMilli())
+}
+
+func (s *RedisStore) Unban(key string) error {
+	_, err := s.client.Eval(context.Background(), redisDeleteLua, []string{s.banKey(key)})
+	return err
+}
+
+// Bans scans for ban state, so the client must implement RedisScanClient.
+func (s *RedisStore) Bans() ([]Ban, error) {
+	var bans []Ban
+	err := s.scanSuffix(":ban", func(key, _ string) error {
+		ban, err := s.Ban(key)
+		if err == nil && !ban.Until.IsZero() {
+			bans = append(bans, ban)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// parseRedisBan reads a {level, banned_until_ms} reply. Missing fields are
+// nil and mean the key was never banned.
+func parseRedisBan(key string, result any, nowMs int64) (Ban, error) {
+	values, ok := result.([]any)
+	if !ok || len(values) != 2 {
+		return Ban{}, fmt.Errorf("unexpected redis lua result: %T", result)
+	}
+	if values[0] == nil || values[1] == nil {
+		return Ban{}, nil
+	}
+	level, err := toInt64(values[0])
+	if err != nil {
+		return Ban{}, err
+	}
+	untilMs, err := toInt64(values[1])
+	if err != nil {
+		return Ban{}, err
+	}
+	if untilMs <= nowMs {
+		return Ban{}, nil
+	}
+	return Ban{Key: key, Level: int(level), Until: time.UnixMilli(untilMs)}, nil
+}
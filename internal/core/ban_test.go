@@ -0,0 +1,315 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now().Truncate(time.Millisecond)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var testBanPolicy = BanPolicy{
+	Threshold: 3,
+	Window:    time.Minute,
+	Duration:  10 * time.Second,
+	Decay:     time.Hour,
+}
+
+func TestManagerBansEscalate(t *testing.T) {
+	clock := newTestClock()
+	for name, newStore := range map[string]func(t *testing.T) Store{
+		"memory": func(*testing.T) Store {
+			return NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock.Now})
+		},
+		"redis": func(t *testing.T) Store {
+			s, err := NewRedisStore(newFakeRedisClusterClient(), RedisStoreOptions{Now: clock.Now})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return s
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewManagerWithStore(newStore(t), 1, 1, time.Hour, time.Hour, time.Hour, WithBans(testBanPolicy))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer m.Close()
+
+			m.Allow("attacker")
+			for i := range 3 {
+				if d, _ := m.AllowDecision("attacker"); !d.BannedUntil.IsZero() {
+					t.Fatalf("denial %d: expected no ban up to the threshold, got %+v", i+1, d)
+				}
+			}
+			d, _ := m.AllowDecision("attacker")
+			if d.Allowed || !d.BannedUntil.Equal(clock.Now().Add(10*time.Second)) {
+				t.Fatalf("expected the fourth denial to ban for 10s, got %+v", d)
+			}
+			if ban, err := m.Ban("attacker"); err != nil || ban.Level != 1 {
+				t.Fatalf("expected a level 1 ban, got %+v, %v", ban, err)
+			}
+
+			// A full bucket does not help while banned.
+			if err := m.Reset("attacker"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d, _ := m.AllowDecision("attacker"); d.Allowed || d.BannedUntil.IsZero() || d.RetryAfter != 10*time.Second {
+				t.Fatalf("expected a banned key to be denied for the rest of its ban, got %+v", d)
+			}
+
+			clock.Advance(11 * time.Second)
+			if d, _ := m.AllowDecision("attacker"); !d.Allowed {
+				t.Fatalf("expected the ban to have expired, got %+v", d)
+			}
+			for range 4 {
+				d, _ = m.AllowDecision("attacker")
+			}
+			if !d.BannedUntil.Equal(clock.Now().Add(20 * time.Second)) {
+				t.Fatalf("expected the second ban to last 20s, got %+v", d)
+			}
+
+			bans, err := m.Bans()
+			if err != nil || len(bans) != 1 || bans[0].Key != "attacker" || bans[0].Level != 2 {
+				t.Fatalf("unexpected bans %+v, %v", bans, err)
+			}
+			if err := m.Unban("attacker"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ban, _ := m.Ban("attacker"); !ban.Until.IsZero() {
+				t.Fatalf("expected the ban to be lifted, got %+v", ban)
+			}
+		})
+	}
+}
+
+func TestRedisStoreBansTakeOneEvalPerDecision(t *testing.T) {
+	client := newFakeRedisClusterClient()
+	s, err := NewRedisStore(client, RedisStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(s, 1, 1, time.Hour, time.Hour, time.Hour, WithBans(testBanPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	for i := range 6 {
+		before := client.evals
+		d, _ := m.AllowDecision("k")
+		if n := client.evals - before; n != 1 {
+			t.Fatalf("request %d (%+v): expected a single EVAL, got %d", i+1, d, n)
+		}
+	}
+	if ban, _ := m.Ban("k"); ban.Level != 1 {
+		t.Fatalf("expected the key to be banned, got %+v", ban)
+	}
+}
+
+func TestManagerReportsBannedDecisions(t *testing.T) {
+	metrics := NewMetrics()
+	obs := &recordingObserver{}
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithBans(testBanPolicy), WithMetrics(metrics), WithObserver(obs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	for range 6 {
+		m.Allow("attacker")
+	}
+
+	var b strings.Builder
+	_ = metrics.Write(&b)
+	for _, want := range []string{
+		`ratelimiter_decisions_total{policy="default",result="allowed"} 1`,
+		`ratelimiter_decisions_total{policy="default",result="denied"} 3`,
+		`ratelimiter_decisions_total{policy="default",result="banned"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, b.String())
+		}
+	}
+	if len(obs.decisions) != 6 || obs.decisions[5].Decision.BannedUntil.IsZero() {
+		t.Fatalf("expected observers to see banned decisions, got %+v", obs.decisions)
+	}
+	if top := m.TopDenied(1); len(top) != 1 || top[0].Denials != 5 {
+		t.Fatalf("expected banned requests to count as denials, got %+v", top)
+	}
+}
+
+func TestMemoryStoreBansConcurrentDenialsOnce(t *testing.T) {
+	clock := newTestClock()
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock.Now})
+	policy := testBanPolicy
+	if err := policy.normalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+	g := allowGuard{bans: &policy, recordDenials: true}
+
+	var mu sync.Mutex
+	var allowed, denied, banned, bans int
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, ban, _ := s.allowGuarded(context.Background(), "k", cfg, g)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case d.Allowed:
+				allowed++
+			case d.BannedUntil.IsZero():
+				denied++
+			default:
+				banned++
+			}
+			if !ban.Until.IsZero() {
+				bans++
+			} else if !d.BannedUntil.IsZero() && d.RetryAfter != policy.Duration {
+				t.Errorf("expected Retry-After %v from the store clock, got %v", policy.Duration, d.RetryAfter)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 1 || denied != policy.Threshold || banned != 49-policy.Threshold || bans != 1 {
+		t.Fatalf("expected 1 allowed, %d denied and the rest banned by a single request, got %d, %d, %d and %d bans",
+			policy.Threshold, allowed, denied, banned, bans)
+	}
+}
+
+func TestMemoryStoreBanLevelDecays(t *testing.T) {
+	clock := newTestClock()
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock.Now})
+	policy := testBanPolicy
+	if err := policy.normalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ban := func() Ban {
+		var b Ban
+		for range policy.Threshold + 1 {
+			b, _ = s.RecordDenial("k", policy)
+		}
+		return b
+	}
+	if b := ban(); b.Level != 1 {
+		t.Fatalf("expected level 1, got %+v", b)
+	}
+	clock.Advance(policy.Duration + policy.Decay)
+	if b := ban(); b.Level != 1 {
+		t.Fatalf("expected the level to reset after the decay, got %+v", b)
+	}
+
+	clock.Advance(policy.Duration + policy.Decay + policy.Window)
+	_ = s.DeleteInactiveBuckets(clock.Now())
+	for i := range s.shards {
+		if n := len(s.shards[i].bans); n != 0 {
+			t.Fatalf("expected expired ban state to be pruned, got %d entries in shard %d", n, i)
+		}
+	}
+}
+
+func TestBanPolicyDurationIsCapped(t *testing.T) {
+	p := BanPolicy{Threshold: 1, Window: time.Second, Duration: time.Minute, MaxDuration: 5 * time.Minute}
+	if err := p.normalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for level, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 5 * time.Minute, 100: 5 * time.Minute} {
+		if got := p.duration(level); got != want {
+			t.Fatalf("level %d: expected %v, got %v", level, want, got)
+		}
+	}
+}
+
+func TestWithBansValidation(t *testing.T) {
+	if _, err := NewManagerWithStore(allowOnlyStore{}, 1, 1, time.Hour, time.Minute, time.Hour, WithBans(testBanPolicy)); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported for a store without bans, got %v", err)
+	}
+	for _, p := range []BanPolicy{
+		{Window: time.Minute, Duration: time.Second},
+		{Threshold: 1, Duration: time.Second},
+		{Threshold: 1, Window: time.Minute},
+		{Threshold: 1, Window: time.Minute, Duration: time.Second, Multiplier: 0.5},
+		{Threshold: 1, Window: time.Minute, Duration: time.Hour, MaxDuration: time.Minute},
+	} {
+		if _, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithBans(p)); err == nil {
+			t.Fatalf("expected error for policy %+v", p)
+		}
+	}
+}
+
+func TestMiddlewareAndAdminBans(t *testing.T) {
+	m, err := NewManager(1, 1, 100*time.Millisecond, time.Minute, time.Hour, WithBans(BanPolicy{
+		Threshold: 2,
+		Window:    time.Minute,
+		Duration:  time.Hour,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+	handler := m.Middleware(RemoteIP)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = "192.0.2.1:1"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for range 3 {
+		serve()
+	}
+	rec := serve()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("expected a 429 with the ban's Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+
+	srv := newAdminTestServer(t, m)
+	var bans struct {
+		Bans []adminBan `json:"bans"`
+	}
+	if code := adminRequest(t, srv, http.MethodGet, "/bans", "", &bans); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(bans.Bans) != 1 || bans.Bans[0].Key != "192.0.2.1" || bans.Bans[0].Level != 1 {
+		t.Fatalf("unexpected bans %+v", bans)
+	}
+	if code := adminRequest(t, srv, http.MethodDelete, "/bans/192.0.2.1", "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("expected the unbanned key to pass once its bucket refilled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const defaultIPv6PrefixLen = 64
+
+// Client IP headers understood by ClientIPKeyFunc.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+type ClientIPOptions struct {
+	// TrustedProxies lists the CIDRs (or single IPs) of proxies whose
+	// forwarding headers are believed. Headers from any other peer are
+	// ignored, so clients cannot pick their own key.
+	TrustedProxies []string
+	// Headers are the forwarding headers to consult, in order; the first one
+	// present is used. Defaults to Forwarded, X-Forwarded-For, X-Real-IP.
+	Headers []string
+	// IPv4PrefixLen aggregates IPv4 clients into networks of this size, e.g.
+	// 24. Defaults to 32 (one key per address).
+	IPv4PrefixLen int
+	// IPv6PrefixLen aggregates IPv6 clients into networks of this size.
+	// Defaults to 64, the usual allocation for a single host or site; set 128
+	// for one key per address.
+	IPv6PrefixLen int
+}
+
+// RemoteIP is a Middleware key function returning the connection's IP
+// address without the port. It ignores forwarding headers.
+func RemoteIP(r *http.Request) string {
+	if addr, ok := parseRemoteAddr(r.RemoteAddr); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// ClientIPKeyFunc returns a Middleware key function that resolves the
+// client IP behind trusted proxies and aggregates it into a network prefix.
+//
+// Forwarding headers are only used when the connection comes from a trusted
+// proxy. The header's hop list is then walked from the nearest hop outwards,
+// skipping trusted proxies; the first untrusted address is the client.
+func ClientIPKeyFunc(opts ClientIPOptions) (func(*http.Request) string, error) {
+	e, err := newClientIPExtractor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return e.key, nil
+}
+
+type clientIPExtractor struct {
+	trusted []netip.Prefix
+	headers []string
+	v4Bits  int
+	v6Bits  int
+}
+
+func newClientIPExtractor(opts ClientIPOptions) (*clientIPExtractor, error) {
+	e := &clientIPExtractor{
+		headers: opts.Headers,
+		v4Bits:  opts.IPv4PrefixLen,
+		v6Bits:  opts.IPv6PrefixLen,
+	}
+	if len(e.headers) == 0 {
+		e.headers = []string{HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP}
+	}
+	for _, h := range e.headers {
+		switch http.CanonicalHeaderKey(h) {
+		case http.CanonicalHeaderKey(HeaderForwarded), http.CanonicalHeaderKey(HeaderXForwardedFor), http.CanonicalHeaderKey(HeaderXRealIP):
+		default:
+			return nil, fmt.Errorf("unsupported client IP header %q", h)
+		}
+	}
+	if e.v4Bits == 0 {
+		e.v4Bits = 32
+	}
+	if e.v6Bits == 0 {
+		e.v6Bits = defaultIPv6PrefixLen
+	}
+	if e.v4Bits < 1 || e.v4Bits > 32 {
+		return nil, errors.New("IPv4 prefix length must be between 1 and 32")
+	}
+	if e.v6Bits < 1 || e.v6Bits > 128 {
+		return nil, errors.New("IPv6 prefix length must be between 1 and 128")
+	}
+
+	for _, s := range opts.TrustedProxies {
+		p, err := parsePrefixOrAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		e.trusted = append(e.trusted, p)
+	}
+	return e, nil
+}
+
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), max(p.Bits()-96, 0))
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (e *clientIPExtractor) isTrusted(addr netip.Addr) bool {
+	for _, p := range e.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *clientIPExtractor) key(r *http.Request) string {
+	remote, ok := parseRemoteAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	return e.aggregate(e.clientIP(r, remote))
+}
+
+// clientIP resolves the client address for a request received from remote.
+func (e *clientIPExtractor) clientIP(r *http.Request, remote netip.Addr) netip.Addr {
+	if !e.isTrusted(remote) {
+		return remote
+	}
+	for _, h := range e.headers {
+		values := r.Header.Values(h)
+		if len(values) == 0 {
+			continue
+		}
+
+		var hops []netip.Addr
+		switch http.CanonicalHeaderKey(h) {
+		case http.CanonicalHeaderKey(HeaderForwarded):
+			hops = parseForwardedFor(values)
+		case http.CanonicalHeaderKey(HeaderXForwardedFor):
+			hops = parseXForwardedFor(values)
+		default:
+			if addr, ok := parseHopAddr(values[0]); ok {
+				hops = []netip.Addr{addr}
+			}
+		}
+		if addr, ok := e.firstUntrusted(hops); ok {
+			return addr
+		}
+		return remote
+	}
+	return remote
+}
+
+// firstUntrusted walks hops from the nearest (last) one and returns the
+// first address that is not a trusted proxy. An invalid hop (zero Addr)
+// stops the walk, since nothing beyond it can be attributed. When every hop
+// is trusted the farthest one is returned.
+func (e *clientIPExtractor) firstUntrusted(hops []netip.Addr) (netip.Addr, bool) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !hops[i].IsValid() {
+			return netip.Addr{}, false
+		}
+		if !e.isTrusted(hops[i]) {
+			return hops[i], true
+		}
+	}
+	if len(hops) > 0 {
+		return hops[0], true
+	}
+	return netip.Addr{}, false
+}
+
+func (e *clientIPExtractor) aggregate(addr netip.Addr) string {
+	bits := e.v6Bits
+	if addr.Is4() {
+		bits = e.v4Bits
+	}
+	if bits == addr.BitLen() {
+		return addr.String()
+	}
+	p, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return p.String()
+}
+
+func parseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return ap.Addr().Unmap().WithZone(""), true
+	}
+	return parseHopAddr(remoteAddr)
+}
+
+// parseHopAddr parses an address from a forwarding header, with or without
+// a port and IPv6 brackets.
+func parseHopAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// parseXForwardedFor returns the hops of all X-Forwarded-For values, the
+// client first. Unparseable entries become zero Addrs.
+func parseXForwardedFor(values []string) []netip.Addr {
+	var hops []netip.Addr
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			addr, _ := parseHopAddr(part)
+			hops = append(hops, addr)
+		}
+	}
+	return hops
+}
+
+// parseForwardedFor returns the for= parameter of each RFC 7239 forwarded
+// element, the client first. Obfuscated identifiers and "unknown" become
+// zero Addrs.
+func parseForwardedFor(values []string) []netip.Addr {
+	var hops []netip.Addr
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+				addr, _ := parseHopAddr(strings.Trim(value, `"`))
+				hops = append(hops, addr)
+			}
+		}
+	}
+	return hops
+}
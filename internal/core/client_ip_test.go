@@ -0,0 +1,94 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteIPStripsPort(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.1:1234":          "192.0.2.1",
+		"[2001:db8::1]:443":       "2001:db8::1",
+		"[::ffff:192.0.2.1]:8080": "192.0.2.1",
+		"192.0.2.1":               "192.0.2.1",
+		"@":                       "@",
+	}
+	for remote, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		if got := RemoteIP(r); got != want {
+			t.Fatalf("RemoteIP(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestClientIPKeyFunc(t *testing.T) {
+	keyFunc, err := ClientIPKeyFunc(ClientIPOptions{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::1"},
+		IPv6PrefixLen:  128,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "198.51.100.7:1000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "198.51.100.7"},
+		{"x-forwarded-for from trusted proxy", "10.0.0.1:1000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "203.0.113.9"},
+		{"spoofed leftmost entries are skipped", "10.0.0.1:1000", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"all hops trusted uses farthest", "10.0.0.1:1000", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.2"}, "10.1.1.1"},
+		{"garbage hop stops the walk", "10.0.0.1:1000", map[string]string{"X-Forwarded-For": "203.0.113.9, nonsense"}, "10.0.0.1"},
+		{"forwarded header", "10.0.0.1:1000", map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"forwarded preferred over x-forwarded-for", "10.0.0.1:1000", map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "203.0.113.9"}, "192.0.2.60"},
+		{"forwarded unknown falls back to peer", "10.0.0.1:1000", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+		{"x-real-ip", "[2001:db8:ffff::1]:443", map[string]string{"X-Real-IP": "203.0.113.9"}, "203.0.113.9"},
+		{"no headers uses peer", "10.0.0.1:1000", nil, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = c.remote
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			if got := keyFunc(r); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestClientIPKeyFuncAggregatesPrefixes(t *testing.T) {
+	keyFunc, err := ClientIPKeyFunc(ClientIPOptions{IPv4PrefixLen: 24})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "[2001:db8:1:2:aaaa::1]:443"
+	if got := keyFunc(r); got != "2001:db8:1:2::/64" {
+		t.Fatalf("expected IPv6 /64 aggregation by default, got %q", got)
+	}
+	r.RemoteAddr = "192.0.2.77:80"
+	if got := keyFunc(r); got != "192.0.2.0/24" {
+		t.Fatalf("expected IPv4 /24 aggregation, got %q", got)
+	}
+}
+
+func TestClientIPKeyFuncValidatesOptions(t *testing.T) {
+	for _, opts := range []ClientIPOptions{
+		{TrustedProxies: []string{"not-an-ip"}},
+		{Headers: []string{"X-Client"}},
+		{IPv4PrefixLen: 33},
+		{IPv6PrefixLen: -1},
+	} {
+		if _, err := ClientIPKeyFunc(opts); err == nil {
+			t.Fatalf("expected error for %+v", opts)
+		}
+	}
+}
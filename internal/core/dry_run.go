@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// WithDryRun makes the Manager compute and report decisions without
+// enforcing them: every decision is allowed, with Decision.WouldDeny set
+// when the request would have been denied. Metrics count those as
+// dry_run_denied; observers, traces and logs see the computed decision.
+func WithDryRun() ManagerOption {
+	return func(m *Manager) {
+		m.dryRun = true
+	}
+}
+
+// defaultShadowMaxKeys bounds the shadow policy's own MemoryStore when the
+// live store is not a MemoryStore whose bound it can copy.
+const defaultShadowMaxKeys = 100000
+
+// WithShadowPolicy evaluates cfg alongside the live config for every key
+// and reports the result under policy name, without enforcing it. Shadow
+// buckets are kept apart from the live keys: by default in a MemoryStore of
+// the Manager's own, bounded like the live store if that is a MemoryStore
+// and to 100000 keys otherwise, so with a shared live store each instance
+// shadows only the requests it sees. WithShadowStore changes where they are
+// kept.
+func WithShadowPolicy(name string, cfg BucketConfig) ManagerOption {
+	return func(m *Manager) {
+		m.shadow = &shadowPolicy{name: name, cfg: cfg}
+	}
+}
+
+// WithShadowStore keeps the shadow policy's buckets in store. Pass a store
+// shared by all instances, such as a RedisStore with its own KeyPrefix, so
+// the shadow policy sees cluster-wide traffic like the live one. It must not
+// hold the live buckets. The Manager cleans it up and closes it on Stop.
+func WithShadowStore(store Store) ManagerOption {
+	return func(m *Manager) {
+		m.shadowStore = store
+	}
+}
+
+type shadowPolicy struct {
+	name    string
+	cfg     BucketConfig
+	store   Store
+	metrics *policyMetrics
+	log     *managerLogger
+}
+
+func (s *shadowPolicy) init(m *Manager) error {
+	if s.name == "" {
+		return errors.New("shadow policy name cannot be empty")
+	}
+	if s.name == m.policy {
+		return errors.New("shadow policy name must differ from the live policy name")
+	}
+	if err := validateTokenBucketConfig(s.cfg.Capacity, s.cfg.RefillRate, s.cfg.Interval); err != nil {
+		return fmt.Errorf("shadow policy: %w", err)
+	}
+	s.log = newManagerLogger(m.logger, s.name, m.denialLogRate)
+	s.store = m.shadowStore
+	if s.store == nil {
+		s.store = newShadowMemoryStore(m.store)
+	}
+	return nil
+}
+
+// newShadowMemoryStore returns a MemoryStore for shadow buckets, bounded
+// like live if it is a MemoryStore. It always evicts, since rejecting new
+// keys would show up as shadow denials.
+func newShadowMemoryStore(live Store) *MemoryStore {
+	maxKeys := defaultShadowMaxKeys
+	if ms, ok := live.(*MemoryStore); ok {
+		maxKeys = int(ms.maxKeys)
+	}
+	return NewMemoryStoreWithOptions(MemoryStoreOptions{MaxKeys: maxKeys})
+}
+
+// evaluateShadow runs the shadow policy for key. Its outcome, including
+// errors, is only reported.
+func (m *Manager) evaluateShadow(ctx context.Context, key string) {
+	s := m.shadow
+	_, _ = m.evaluate(ctx, evaluation{
+		policy:  s.name,
+		key:     key,
+		store:   s.store,
+		cfg:     s.cfg,
+		dryRun:  true,
+		shadow:  true,
+		metrics: s.metrics,
+		log:     s.log,
+	})
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerDryRunReportsButDoesNotEnforce(t *testing.T) {
+	metrics := NewMetrics()
+	obs := &recordingObserver{}
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour,
+		WithDryRun(), WithMetrics(metrics), WithObserver(obs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	first, _ := m.AllowDecision("a")
+	second, _ := m.AllowDecision("a")
+	if !first.Allowed || !first.DryRun || first.WouldDeny {
+		t.Fatalf("unexpected first decision %+v", first)
+	}
+	if !second.Allowed || !second.DryRun || !second.WouldDeny {
+		t.Fatalf("expected second decision to be allowed but flagged, got %+v", second)
+	}
+	if !m.Allow("a") {
+		t.Fatal("expected Allow to pass in dry-run mode")
+	}
+
+	if e := obs.decisions[1]; e.Decision.Allowed || !e.DryRun || e.Shadow {
+		t.Fatalf("expected observers to see the computed denial, got %+v", e)
+	}
+	var b strings.Builder
+	_ = metrics.Write(&b)
+	if !strings.Contains(b.String(), `ratelimiter_decisions_total{policy="default",result="dry_run_denied"} 2`) ||
+		!strings.Contains(b.String(), `ratelimiter_decisions_total{policy="default",result="denied"} 0`) {
+		t.Fatalf("expected dry-run denials to be counted separately, got:\n%s", b.String())
+	}
+}
+
+func TestMiddlewareDryRun(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := m.Middleware(func(*http.Request) string { return "k" }, WithMiddlewareDryRun())(ok)
+
+	var results []string
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected dry-run middleware to pass requests through, got %d", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") != "" {
+			t.Fatal("expected no Retry-After in dry-run mode")
+		}
+		results = append(results, rec.Header().Get("X-RateLimit-Dry-Run"))
+	}
+	if results[0] != "allowed" || results[1] != "denied" {
+		t.Fatalf("unexpected dry-run headers %v", results)
+	}
+
+	// The Manager itself still enforces for other callers.
+	if m.Allow("k") {
+		t.Fatal("expected the Manager to enforce outside the dry-run middleware")
+	}
+}
+
+func TestManagerShadowPolicy(t *testing.T) {
+	metrics := NewMetrics()
+	obs := &recordingObserver{}
+	m, err := NewManager(5, 1, time.Hour, time.Minute, time.Hour,
+		WithPolicyName("api"),
+		WithShadowPolicy("api-strict", BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Hour}),
+		WithMetrics(metrics), WithObserver(obs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	for i := range 4 {
+		if !m.Allow("a") {
+			t.Fatalf("expected live policy to allow request %d", i+1)
+		}
+	}
+
+	var shadowDenied int
+	for _, e := range obs.decisions {
+		if e.Shadow {
+			if e.Policy != "api-strict" || !e.DryRun {
+				t.Fatalf("unexpected shadow event %+v", e)
+			}
+			if !e.Decision.Allowed {
+				shadowDenied++
+			}
+		}
+	}
+	if shadowDenied != 2 {
+		t.Fatalf("expected the shadow policy to deny 2 requests, got %d", shadowDenied)
+	}
+
+	var b strings.Builder
+	_ = metrics.Write(&b)
+	for _, want := range []string{
+		`ratelimiter_decisions_total{policy="api",result="allowed"} 4`,
+		`ratelimiter_decisions_total{policy="api-strict",result="dry_run_denied"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, b.String())
+		}
+	}
+	if top := m.TopDenied(10); len(top) != 0 {
+		t.Fatalf("expected shadow denials not to count as live denials, got %+v", top)
+	}
+	if n, err := m.KeyCount(); err != nil || n != 1 {
+		t.Fatalf("expected shadow buckets to stay out of the live store, got %d keys, %v", n, err)
+	}
+}
+
+func TestManagerShadowStoreSharedAcrossInstances(t *testing.T) {
+	shadow := NewMemoryStore()
+	obs := &recordingObserver{}
+	var managers []*Manager
+	for range 2 {
+		m, err := NewManager(5, 1, time.Hour, time.Minute, time.Hour,
+			WithShadowPolicy("strict", BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Hour}),
+			WithShadowStore(shadow), WithObserver(obs))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer m.Close()
+		managers = append(managers, m)
+	}
+
+	managers[0].Allow("a")
+	managers[1].Allow("a")
+	managers[0].Allow("a")
+
+	var shadowDenied int
+	for _, e := range obs.decisions {
+		if e.Shadow && !e.Decision.Allowed {
+			shadowDenied++
+		}
+	}
+	if shadowDenied != 1 {
+		t.Fatalf("expected the shared shadow store to count both instances, got %d shadow denials", shadowDenied)
+	}
+}
+
+func TestManagerLogsShadowStoreErrors(t *testing.T) {
+	var buf logBuffer
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour,
+		WithShadowPolicy("strict", BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}),
+		WithShadowStore(closeErrorStore{}), WithLogger(newTestLogger(&buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Close()
+	if len(buf.withMessage(t, "rate limiter shadow store close failed")) != 1 {
+		t.Fatal("expected the shadow store close error to be logged")
+	}
+
+	if _, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithShadowStore(NewMemoryStore())); err == nil {
+		t.Fatal("expected a shadow store without a shadow policy to be rejected")
+	}
+}
+
+func TestManagerShadowPolicyValidates(t *testing.T) {
+	valid := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Second}
+	for _, opt := range []ManagerOption{
+		WithShadowPolicy("", valid),
+		WithShadowPolicy(defaultPolicyName, valid),
+		WithShadowPolicy("strict", BucketConfig{}),
+	} {
+		if _, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, opt); err == nil {
+			t.Fatal("expected invalid shadow policy to be rejected")
+		}
+	}
+}
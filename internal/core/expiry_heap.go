@@ -0,0 +1,40 @@
+package core
+
+// expiryHeap is a min-heap of memory store entries ordered by the last-seen
+// time recorded when the entry was pushed or last re-checked. Allow does not
+// update it; cleanup lazily re-checks entries whose recorded time has passed
+// the cutoff, so only candidates for expiry are ever visited.
+type expiryHeap []*memoryEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*memoryEntry)
+	e.heapIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.heapIndex = -1
+	*h = old[:n-1]
+	return e
+}
+
+func (h expiryHeap) peek() (*memoryEntry, int64, bool) {
+	if len(h) == 0 {
+		return nil, 0, false
+	}
+	return h[0], h[0].expireAt, true
+}
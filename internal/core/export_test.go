@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Exported for tests in package core_test.
+var NewFakeRedisEvalClient = newFakeRedisEvalClient
+
+type FailingRedisEvalClient struct{}
+
+func (FailingRedisEvalClient) Eval(context.Context, string, []string, ...any) (any, error) {
+	return nil, errors.New("redis unavailable")
+}
+
+var NewFakeRedisClusterClient = newFakeRedisClusterClient
+
+// GuardedRedisStore decides every request the way a Manager does with access
+// lists and bans in use: the key's list and ban are checked and its denials
+// counted in the token bucket script.
+type GuardedRedisStore struct {
+	*RedisStore
+}
+
+// guardedTestBans counts denials without ever reaching its threshold.
+var guardedTestBans = BanPolicy{
+	Threshold:   math.MaxInt32,
+	Window:      time.Hour,
+	Duration:    time.Minute,
+	Multiplier:  defaultBanMultiplier,
+	MaxDuration: time.Hour,
+	Decay:       time.Hour,
+}
+
+func (s GuardedRedisStore) Allow(key string, cfg BucketConfig) (Decision, error) {
+	d, _, err := s.allowGuarded(context.Background(), key, cfg, allowGuard{
+		lists:         true,
+		bans:          &guardedTestBans,
+		recordDenials: true,
+	})
+	return d, err
+}
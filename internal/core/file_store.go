@@ -0,0 +1,454 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	fileStoreLogName = "buckets.log"
+
+	defaultFileStoreSyncInterval     = time.Second
+	defaultFileStoreCompactThreshold = 10000
+)
+
+var errFileStoreClosed = errors.New("file store is closed")
+
+// SyncPolicy controls when FileStore forces log writes to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncPeriodic fsyncs the log every FileStoreOptions.SyncInterval. A
+	// machine crash can lose up to one interval of updates.
+	SyncPeriodic SyncPolicy = iota
+	// SyncAlways fsyncs before a decision is returned, so nothing
+	// acknowledged is lost. Concurrent requests share one fsync.
+	SyncAlways
+	// SyncNever leaves flushing to the operating system. Updates survive a
+	// process crash but not a machine crash.
+	SyncNever
+)
+
+type FileStoreOptions struct {
+	// Now overrides the clock used for refills and last-seen tracking.
+	// Defaults to time.Now.
+	Now func() time.Time
+	// SyncPolicy defaults to SyncPeriodic.
+	SyncPolicy SyncPolicy
+	// SyncInterval is the fsync period for SyncPeriodic. Defaults to 1s.
+	SyncInterval time.Duration
+	// CompactThreshold is the number of log records after which
+	// DeleteInactiveBuckets rewrites the log, provided it holds at least twice
+	// as many records as live keys. Defaults to 10000.
+	CompactThreshold int
+	// Logger, if set, receives log write and sync failures and log recovery
+	// notices.
+	Logger *slog.Logger
+}
+
+// FileStore keeps buckets in memory and persists every change to an
+// append-only log in a local directory, so state survives crashes without an
+// external service. The log is replayed on open and compacted during cleanup.
+//
+// Decisions are made in memory without touching the log. Changed keys are
+// queued and a background writer appends their latest state in batches, so
+// a key updated many times between batches is written once. A failed write
+// does not fail the request: the decision stands and the error goes to
+// FileStoreOptions.Logger. A directory must only be opened by one process.
+type FileStore struct {
+	dir  string
+	mem  *MemoryStore
+	opts FileStoreOptions
+
+	// mu serializes log writes, syncs and compaction.
+	mu      sync.Mutex
+	log     *os.File
+	records int
+
+	// pendingMu guards the batch of keys waiting to be written and closed.
+	pendingMu sync.Mutex
+	pending   *fileStoreBatch
+	closed    bool
+	wake      chan struct{}
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// fileStoreBatch is a set of keys whose state has changed since the last
+// write. done is closed once the batch is written, and synced under
+// SyncAlways.
+type fileStoreBatch struct {
+	keys map[string]struct{}
+	done chan struct{}
+}
+
+type fileLogRecord struct {
+	Op     string          `json:"op"`
+	Bucket *bucketSnapshot `json:"bucket,omitempty"`
+	Key    string          `json:"key,omitempty"`
+}
+
+const (
+	fileLogSet    = "set"
+	fileLogDelete = "del"
+)
+
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("directory cannot be empty")
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultFileStoreSyncInterval
+	}
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = defaultFileStoreCompactThreshold
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, fileStoreLogName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+
+	s := &FileStore{
+		dir:    dir,
+		mem:    NewMemoryStoreWithOptions(MemoryStoreOptions{Now: opts.Now}),
+		opts:   opts,
+		log:    log,
+		wake:   make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+	if err := s.replay(); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.writeLoop()
+	if opts.SyncPolicy == SyncPeriodic {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// replay rebuilds the in-memory state from the log. A torn final record left
+// by a crash mid-write is truncated; corruption anywhere else is an error.
+func (s *FileStore) replay() error {
+	r := bufio.NewReader(s.log)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := s.log.Truncate(offset); err != nil {
+					return fmt.Errorf("truncate torn log record: %w", err)
+				}
+				if s.opts.Logger != nil {
+					s.opts.Logger.Warn("file store truncated torn log record",
+						slog.String("dir", s.dir),
+						slog.Int64("offset", offset),
+					)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read log: %w", err)
+		}
+
+		var rec fileLogRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return fmt.Errorf("log record at offset %d: %w", offset, err)
+		}
+		if err := s.apply(rec); err != nil {
+			return fmt.Errorf("log record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		s.records++
+	}
+}
+
+func (s *FileStore) apply(rec fileLogRecord) error {
+	switch rec.Op {
+	case fileLogSet:
+		if rec.Bucket == nil {
+			return errors.New("set record without bucket")
+		}
+		if err := rec.Bucket.validate(); err != nil {
+			return err
+		}
+		s.mem.restoreEntry(*rec.Bucket)
+	case fileLogDelete:
+		s.mem.deleteKey(rec.Key)
+	default:
+		return fmt.Errorf("unknown log operation %q", rec.Op)
+	}
+	return nil
+}
+
+func (s *FileStore) Allow(key string, cfg BucketConfig) (Decision, error) {
+	if s.isClosed() {
+		return Decision{}, errFileStoreClosed
+	}
+	decision, err := s.mem.Allow(key, cfg)
+	if err != nil {
+		return Decision{}, err
+	}
+	done, err := s.enqueue(key)
+	if err != nil {
+		return Decision{}, err
+	}
+	if s.opts.SyncPolicy == SyncAlways {
+		<-done
+	}
+	return decision, nil
+}
+
+func (s *FileStore) isClosed() bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	return s.closed
+}
+
+// enqueue marks key as changed and wakes the writer. It returns a channel
+// closed once the key's state from after the change is written.
+func (s *FileStore) enqueue(key string) (<-chan struct{}, error) {
+	s.pendingMu.Lock()
+	if s.closed {
+		s.pendingMu.Unlock()
+		return nil, errFileStoreClosed
+	}
+	if s.pending == nil {
+		s.pending = &fileStoreBatch{keys: make(map[string]struct{}), done: make(chan struct{})}
+	}
+	s.pending.keys[key] = struct{}{}
+	done := s.pending.done
+	s.pendingMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return done, nil
+}
+
+func (s *FileStore) writeLoop() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.wake:
+			s.flush()
+		case <-s.stopCh:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending batch and reports a failure to the logger, since
+// the requests that queued it have already been decided.
+func (s *FileStore) flush() {
+	if err := s.writePending(); err != nil && s.opts.Logger != nil {
+		s.opts.Logger.Error("file store write failed",
+			slog.String("dir", s.dir),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// writePending appends one record per pending key with the key's current
+// state, or a deletion if it is gone, and syncs under SyncAlways.
+func (s *FileStore) writePending() error {
+	s.pendingMu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.pendingMu.Unlock()
+	if batch == nil {
+		return nil
+	}
+	defer close(batch.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for key := range batch.keys {
+		rec := fileLogRecord{Op: fileLogDelete, Key: key}
+		if b, ok := s.mem.snapshotKey(key); ok {
+			rec = fileLogRecord{Op: fileLogSet, Bucket: &b}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := s.log.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("append log: %w", err)
+	}
+	s.records += len(batch.keys)
+	if s.opts.SyncPolicy == SyncAlways {
+		if err := s.log.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteInactiveBuckets removes buckets not used since cutoff, records the
+// deletions and compacts the log once it has grown well past the live state.
+func (s *FileStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	if s.isClosed() {
+		return errFileStoreClosed
+	}
+
+	var enqueueErr error
+	s.mem.deleteInactive(cutoff, func(key string) {
+		if _, err := s.enqueue(key); err != nil && enqueueErr == nil {
+			enqueueErr = err
+		}
+	})
+	if enqueueErr != nil {
+		return enqueueErr
+	}
+	if err := s.writePending(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records >= s.opts.CompactThreshold && s.records >= 2*s.mem.Len() {
+		return s.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log so it holds exactly one record per live bucket.
+func (s *FileStore) Compact() error {
+	if s.isClosed() {
+		return errFileStoreClosed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// compact writes the live state to a temporary file and atomically renames it
+// over the log, so a crash at any point leaves either the old or the new log.
+// The caller must hold s.mu.
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(s.dir, fileStoreLogName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create compacted log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	records := 0
+	var encErr error
+	s.mem.forEachBucket(s.mem.nowNanos(), func(b bucketSnapshot) {
+		if encErr == nil {
+			encErr = enc.Encode(fileLogRecord{Op: fileLogSet, Bucket: &b})
+			records++
+		}
+	})
+	if encErr == nil {
+		encErr = w.Flush()
+	}
+	if encErr == nil {
+		encErr = tmp.Sync()
+	}
+	if encErr != nil {
+		tmp.Close()
+		return fmt.Errorf("write compacted log: %w", encErr)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close compacted log: %w", err)
+	}
+
+	path := filepath.Join(s.dir, fileStoreLogName)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace log: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen log: %w", err)
+	}
+	s.log.Close()
+	s.log = log
+	s.records = records
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open store directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync store directory: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) syncLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.log.Sync(); err != nil && s.opts.Logger != nil {
+				s.opts.Logger.Error("file store sync failed",
+					slog.String("dir", s.dir),
+					slog.Any("error", err),
+				)
+			}
+			s.mu.Unlock()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Close stops the background writer after it writes the pending batch,
+// flushes the log and closes it.
+func (s *FileStore) Close() error {
+	s.pendingMu.Lock()
+	if s.closed {
+		s.pendingMu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.pendingMu.Unlock()
+
+	close(s.stopCh)
+	s.wg.Wait()
+
+	syncErr := s.log.Sync()
+	closeErr := s.log.Close()
+	return errors.Join(syncErr, closeErr)
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openFileStoreForTest(t *testing.T, dir string, opts FileStoreOptions) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(dir, opts)
+	if err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+	return s
+}
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	cfg := BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Hour}
+
+	s := openFileStoreForTest(t, dir, FileStoreOptions{SyncPolicy: SyncAlways})
+	_, _ = s.Allow("user", cfg)
+	_, _ = s.Allow("user", cfg)
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+
+	s = openFileStoreForTest(t, dir, FileStoreOptions{})
+	defer s.Close()
+	if d, _ := s.Allow("user", cfg); d.Allowed {
+		t.Fatal("expected exhausted bucket to stay exhausted after reopen")
+	}
+	if d, _ := s.Allow("other", cfg); !d.Allowed {
+		t.Fatal("expected unrelated key to be allowed")
+	}
+}
+
+func TestFileStoreTruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+	s := openFileStoreForTest(t, dir, FileStoreOptions{SyncPolicy: SyncNever})
+	_, _ = s.Allow("user", cfg)
+	_ = s.Close()
+
+	path := filepath.Join(dir, fileStoreLogName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = f.WriteString(`{"op":"set","bucket":{"key":"half`)
+	_ = f.Close()
+
+	var logs logBuffer
+	s = openFileStoreForTest(t, dir, FileStoreOptions{Logger: newTestLogger(&logs)})
+	if d, _ := s.Allow("user", cfg); d.Allowed {
+		t.Fatal("expected records before the torn one to be replayed")
+	}
+	_ = s.Close()
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "half") {
+		t.Fatal("expected torn record to be truncated")
+	}
+	if len(logs.withMessage(t, "file store truncated torn log record")) != 1 {
+		t.Fatal("expected the truncation to be logged")
+	}
+}
+
+func TestFileStoreRejectsCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, fileStoreLogName)
+	if err := os.WriteFile(path, []byte("not json\n{\"op\":\"del\",\"key\":\"a\"}\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := OpenFileStore(dir, FileStoreOptions{}); err == nil {
+		t.Fatal("expected error for corrupt log record")
+	}
+}
+
+func TestFileStoreCleanupCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	clock := func() time.Time { return now }
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+
+	s := openFileStoreForTest(t, dir, FileStoreOptions{Now: clock, CompactThreshold: 2})
+	for range 20 {
+		_, _ = s.Allow("idle", cfg)
+	}
+	now = now.Add(time.Hour)
+	_, _ = s.Allow("active", cfg)
+
+	if err := s.DeleteInactiveBuckets(now.Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if s.records != 1 {
+		t.Fatalf("expected compacted log with 1 record, got %d", s.records)
+	}
+	_ = s.Close()
+
+	s = openFileStoreForTest(t, dir, FileStoreOptions{Now: clock})
+	defer s.Close()
+	if s.mem.Len() != 1 {
+		t.Fatalf("expected 1 key after reopening compacted log, got %d", s.mem.Len())
+	}
+	if d, _ := s.Allow("active", cfg); !d.Allowed || d.Remaining != 3 {
+		t.Fatalf("expected active bucket state to survive compaction, got %+v", d)
+	}
+	if d, _ := s.Allow("idle", cfg); !d.Allowed || d.Remaining != 4 {
+		t.Fatalf("expected deleted bucket to start fresh, got %+v", d)
+	}
+}
+
+func TestFileStoreWriteFailureKeepsDecision(t *testing.T) {
+	var logs logBuffer
+	s := openFileStoreForTest(t, t.TempDir(), FileStoreOptions{SyncPolicy: SyncAlways, Logger: newTestLogger(&logs)})
+	defer s.Close()
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+	s.mu.Lock()
+	_ = s.log.Close()
+	s.mu.Unlock()
+
+	d, err := s.Allow("user", cfg)
+	if err != nil || !d.Allowed {
+		t.Fatalf("expected the decision despite the write failure, got %+v, %v", d, err)
+	}
+	if d, _ := s.Allow("user", cfg); d.Allowed {
+		t.Fatal("expected the spent token to stay spent")
+	}
+	if len(logs.withMessage(t, "file store write failed")) != 2 {
+		t.Fatal("expected both write failures to be logged")
+	}
+}
+
+func TestFileStoreClosed(t *testing.T) {
+	s := openFileStoreForTest(t, t.TempDir(), FileStoreOptions{})
+	_ = s.Close()
+	if _, err := s.Allow("user", BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Second}); err == nil {
+		t.Fatal("expected error using closed store")
+	}
+}
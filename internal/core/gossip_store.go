@@ -0,0 +1,275 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGossipInterval = time.Second
+	defaultGossipBasePath = "/_ratelimiter/gossip"
+)
+
+type GossipStoreOptions struct {
+	// Self is this instance's base URL as the other peers reach it. Required.
+	Self string
+	// Peers is the list of peer base URLs. Self is ignored if present.
+	Peers []string
+	// Interval is how often local consumption is pushed to peers. Defaults
+	// to 1s.
+	Interval time.Duration
+	// BasePath is where Handler is mounted on every peer. Defaults to
+	// "/_ratelimiter/gossip".
+	BasePath string
+	// Client is used to reach other peers. Defaults to a client with a 500ms
+	// timeout.
+	Client *http.Client
+	// Memory configures the local store that enforces limits.
+	Memory MemoryStoreOptions
+	// Logger, if set, receives failed periodic pushes.
+	Logger *slog.Logger
+	// Authorize decides whether a request to Handler comes from a peer. A
+	// nil Authorize rejects every request, since pushed consumption can
+	// drain any key.
+	Authorize func(*http.Request) bool
+	// Header is added to every push to other peers, e.g. a shared
+	// Authorization token checked by Authorize.
+	Header http.Header
+}
+
+// GossipStore enforces limits locally and periodically tells its peers how
+// many tokens it consumed per key. Peers deduct that consumption from their
+// own buckets, so each instance's allowance tracks the global limit minus
+// what the others used.
+//
+// Allow never makes network calls. In exchange a key can overshoot its limit
+// by what the other instances grant within one gossip interval, plus any
+// consumption lost to a failed push.
+type GossipStore struct {
+	self      string
+	basePath  string
+	client    *http.Client
+	authorize func(*http.Request) bool
+	header    http.Header
+	local     *MemoryStore
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	peers   []string
+	pending map[string]*gossipUsage
+
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+type gossipUsage struct {
+	Key        string        `json:"key"`
+	Count      int64         `json:"count"`
+	Capacity   int64         `json:"capacity"`
+	RefillRate int64         `json:"refill_rate"`
+	Interval   time.Duration `json:"interval_ns"`
+}
+
+type gossipMessage struct {
+	From  string         `json:"from"`
+	Usage []*gossipUsage `json:"usage"`
+}
+
+func NewGossipStore(opts GossipStoreOptions) (*GossipStore, error) {
+	if opts.Self == "" {
+		return nil, errors.New("self peer URL cannot be empty")
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultGossipInterval
+	}
+	basePath := strings.TrimSuffix(opts.BasePath, "/")
+	if basePath == "" {
+		basePath = defaultGossipBasePath
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultPeerTimeout}
+	}
+
+	s := &GossipStore{
+		self:      normalizePeer(opts.Self),
+		basePath:  basePath,
+		client:    client,
+		authorize: opts.Authorize,
+		header:    opts.Header,
+		local:     NewMemoryStoreWithOptions(opts.Memory),
+		logger:    opts.Logger,
+		pending:   make(map[string]*gossipUsage),
+		stopCh:    make(chan struct{}),
+	}
+	s.SetPeers(opts.Peers)
+
+	s.wg.Add(1)
+	go s.gossipLoop(interval)
+	return s, nil
+}
+
+// SetPeers replaces the list of peers consumption is pushed to.
+func (s *GossipStore) SetPeers(peers []string) {
+	out := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if p = normalizePeer(p); p != s.self && !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+
+	s.mu.Lock()
+	s.peers = out
+	s.mu.Unlock()
+}
+
+func (s *GossipStore) Allow(key string, cfg BucketConfig) (Decision, error) {
+	decision, err := s.local.Allow(key, cfg)
+	if err != nil || !decision.Allowed {
+		return decision, err
+	}
+
+	s.mu.Lock()
+	u, ok := s.pending[key]
+	if !ok {
+		u = &gossipUsage{
+			Key:        key,
+			Capacity:   cfg.Capacity,
+			RefillRate: cfg.RefillRate,
+			Interval:   cfg.Interval,
+		}
+		s.pending[key] = u
+	}
+	u.Count++
+	s.mu.Unlock()
+
+	return decision, nil
+}
+
+func (s *GossipStore) gossipLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Sync(); err != nil && s.logger != nil {
+				s.logger.Warn("gossip store sync failed", slog.Any("error", err))
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Sync pushes consumption recorded since the last push to every peer right
+// away. It runs automatically every Interval. Consumption is sent once; a
+// peer that cannot be reached misses it.
+func (s *GossipStore) Sync() error {
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	msg := gossipMessage{From: s.self, Usage: make([]*gossipUsage, 0, len(s.pending))}
+	for _, u := range s.pending {
+		msg.Usage = append(msg.Usage, u)
+	}
+	s.pending = make(map[string]*gossipUsage)
+	peers := s.peers
+	s.mu.Unlock()
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.push(peer, payload)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *GossipStore) push(peer string, payload []byte) error {
+	resp, err := postPeer(s.client, peer+s.basePath, s.header, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned %s", peer, resp.Status)
+	}
+	return nil
+}
+
+// Handler receives consumption pushed by other peers. Mount it at BasePath;
+// it must not be reachable from outside the cluster.
+func (s *GossipStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+s.basePath, s.serveGossip)
+	return authorizePeer(s.authorize, mux)
+}
+
+func (s *GossipStore) serveGossip(w http.ResponseWriter, r *http.Request) {
+	var msg gossipMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	for _, u := range msg.Usage {
+		if u == nil || u.Count <= 0 {
+			http.Error(w, "usage count must be greater than 0", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := s.local.nowNanos()
+	for _, u := range msg.Usage {
+		e, err := s.local.entry(u.Key, BucketConfig{
+			Capacity:   u.Capacity,
+			RefillRate: u.RefillRate,
+			Interval:   u.Interval,
+		}, now)
+		if err != nil || e == nil {
+			continue
+		}
+		// A peer cannot have used more than one full bucket.
+		e.bucket.consume(min(u.Count, u.Capacity), now)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *GossipStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	return s.local.DeleteInactiveBuckets(cutoff)
+}
+
+// Close stops gossiping after a final push of pending consumption.
+func (s *GossipStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+		s.wg.Wait()
+		err = s.Sync()
+	})
+	return err
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testGossipPeer struct {
+	store  *GossipStore
+	server *httptest.Server
+}
+
+// startGossipPeers starts n peers that only gossip when Sync is called.
+func startGossipPeers(t *testing.T, n int) []*testGossipPeer {
+	t.Helper()
+	peers := make([]*testGossipPeer, n)
+	urls := make([]string, n)
+	for i := range peers {
+		p := &testGossipPeer{}
+		p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.store.Handler().ServeHTTP(w, r)
+		}))
+		t.Cleanup(p.server.Close)
+		peers[i] = p
+		urls[i] = p.server.URL
+	}
+	for i, p := range peers {
+		store, err := NewGossipStore(GossipStoreOptions{
+			Self:      urls[i],
+			Peers:     urls,
+			Interval:  time.Hour,
+			Authorize: authorizeTestPeer,
+			Header:    testPeerHeader,
+		})
+		if err != nil {
+			t.Fatalf("failed to create gossip store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		p.store = store
+	}
+	return peers
+}
+
+func allowN(t *testing.T, s Store, key string, cfg BucketConfig, n int) int {
+	t.Helper()
+	allowed := 0
+	for range n {
+		d, err := s.Allow(key, cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func syncAll(t *testing.T, peers []*testGossipPeer) {
+	t.Helper()
+	for _, p := range peers {
+		if err := p.store.Sync(); err != nil {
+			t.Fatalf("unexpected sync error: %v", err)
+		}
+	}
+}
+
+func TestGossipStoreDeductsOthersUsage(t *testing.T) {
+	peers := startGossipPeers(t, 3)
+	cfg := BucketConfig{Capacity: 10, RefillRate: 1, Interval: time.Hour}
+
+	if got := allowN(t, peers[0].store, "user", cfg, 4); got != 4 {
+		t.Fatalf("expected 4 allowed on peer 0, got %d", got)
+	}
+	if got := allowN(t, peers[1].store, "user", cfg, 3); got != 3 {
+		t.Fatalf("expected 3 allowed on peer 1, got %d", got)
+	}
+	syncAll(t, peers)
+
+	for i, p := range peers {
+		if got := allowN(t, p.store, "user", cfg, 1); got != 1 {
+			t.Fatalf("peer %d: expected one more request to pass", i)
+		}
+		syncAll(t, peers)
+	}
+	if got := allowN(t, peers[2].store, "user", cfg, 10); got != 0 {
+		t.Fatalf("expected global limit to be exhausted, got %d more allowed", got)
+	}
+}
+
+func TestGossipStoreOvershootIsBounded(t *testing.T) {
+	peers := startGossipPeers(t, 2)
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+
+	// Between two syncs every peer can grant a full bucket.
+	total := allowN(t, peers[0].store, "user", cfg, 10) + allowN(t, peers[1].store, "user", cfg, 10)
+	if total != 10 {
+		t.Fatalf("expected overshoot of one bucket per peer, got %d allowed", total)
+	}
+	syncAll(t, peers)
+
+	// The overshoot is repaid before anything else is granted.
+	if got := allowN(t, peers[0].store, "user", cfg, 5) + allowN(t, peers[1].store, "user", cfg, 5); got != 0 {
+		t.Fatalf("expected peers in debt to deny, got %d allowed", got)
+	}
+}
+
+func TestGossipStoreAllowWithoutNetwork(t *testing.T) {
+	peers := startGossipPeers(t, 2)
+	peers[1].server.Close()
+	cfg := BucketConfig{Capacity: 3, RefillRate: 1, Interval: time.Hour}
+
+	if got := allowN(t, peers[0].store, "user", cfg, 5); got != 3 {
+		t.Fatalf("expected local enforcement with peers down, got %d allowed", got)
+	}
+	if err := peers[0].store.Sync(); err == nil {
+		t.Fatal("expected sync to report unreachable peer")
+	}
+}
+
+func TestGossipStoreRequiresSelf(t *testing.T) {
+	if _, err := NewGossipStore(GossipStoreOptions{}); err == nil {
+		t.Fatal("expected error for missing self URL")
+	}
+}
+
+func TestGossipStoreHandlerRejectsForgedUsage(t *testing.T) {
+	peers := startGossipPeers(t, 1)
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+	post := func(body string, header http.Header) int {
+		req, _ := http.NewRequest(http.MethodPost, peers[0].server.URL+defaultGossipBasePath, strings.NewReader(body))
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	usage := func(count int64) string {
+		return fmt.Sprintf(`{"usage":[{"key":"victim","count":%d,"capacity":5,"refill_rate":1,"interval_ns":3600000000000}]}`, count)
+	}
+
+	if code := post(usage(5), nil); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the peer token, got %d", code)
+	}
+	for _, count := range []int64{0, -100} {
+		if code := post(usage(count), testPeerHeader); code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for count %d, got %d", count, code)
+		}
+	}
+	if d, _ := peers[0].store.Allow("victim", cfg); !d.Allowed || d.Remaining != 4 {
+		t.Fatalf("expected rejected pushes to leave the bucket alone, got %+v", d)
+	}
+
+	// Consumption beyond one bucket is capped, so the debt is at most one
+	// bucket.
+	if code := post(usage(1<<40), testPeerHeader); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if d, _ := peers[0].store.local.Peek("victim", cfg); d.Remaining != 0 {
+		t.Fatalf("expected the bucket to be empty, got %+v", d)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderFormat selects which rate limit response headers Middleware sends.
+// Formats can be combined with |.
+type HeaderFormat int
+
+const (
+	// HeadersLegacy sends X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset (Unix time in seconds). This is the default.
+	HeadersLegacy HeaderFormat = 1 << iota
+	// HeadersDraft sends RateLimit-Limit, RateLimit-Remaining and
+	// RateLimit-Reset (delta seconds), from the early IETF
+	// httpapi-ratelimit-headers drafts.
+	HeadersDraft
+	// HeadersStructured sends the RateLimit-Policy and RateLimit structured
+	// fields from the current IETF httpapi-ratelimit-headers draft.
+	HeadersStructured
+
+	// HeadersNone sends no rate limit headers. Retry-After is still sent on
+	// denial.
+	HeadersNone HeaderFormat = 0
+)
+
+// WithHeaderFormat selects the rate limit headers Middleware sends.
+func WithHeaderFormat(format HeaderFormat) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.headers = format
+	}
+}
+
+// writeRateLimitHeaders sets the headers selected by format for a decision
+// made with cfg.
+func (m *Manager) writeRateLimitHeaders(h http.Header, format HeaderFormat, d Decision, cfg BucketConfig, now time.Time) {
+	limit := strconv.FormatInt(d.Limit, 10)
+	remaining := strconv.FormatInt(d.Remaining, 10)
+	reset := durationCeilSeconds(resetAfter(d, cfg))
+
+	if format&HeadersLegacy != 0 {
+		h.Set("X-RateLimit-Limit", limit)
+		h.Set("X-RateLimit-Remaining", remaining)
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
+	}
+	if format&HeadersDraft != 0 {
+		h.Set("RateLimit-Limit", limit)
+		h.Set("RateLimit-Remaining", remaining)
+		h.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	}
+	if format&HeadersStructured != 0 {
+		name := sfString(m.policy)
+		window := durationCeilSeconds(fullRefillTime(cfg, cfg.Capacity))
+		h.Set("RateLimit-Policy", name+";q="+limit+";w="+strconv.FormatInt(window, 10))
+		h.Set("RateLimit", name+";r="+remaining+";t="+strconv.FormatInt(reset, 10))
+	}
+}
+
+// resetAfter is how long until the quota is available again: until the
+// next token for a denied request, otherwise until the bucket is full.
+func resetAfter(d Decision, cfg BucketConfig) time.Duration {
+	if !d.Allowed || d.WouldDeny {
+		return d.RetryAfter
+	}
+	return fullRefillTime(cfg, d.Limit-d.Remaining)
+}
+
+// fullRefillTime is how long cfg takes to refill missing tokens.
+func fullRefillTime(cfg BucketConfig, missing int64) time.Duration {
+	if missing <= 0 || cfg.RefillRate <= 0 {
+		return 0
+	}
+	intervals := (missing + cfg.RefillRate - 1) / cfg.RefillRate
+	return time.Duration(intervals) * cfg.Interval
+}
+
+var sfStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// sfString encodes s as an RFC 8941 string. Characters outside printable
+// ASCII are not allowed there and are dropped.
+func sfString(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+	return `"` + sfStringEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serveOnce(t *testing.T, handler http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func newHeaderTestHandler(t *testing.T, opts ...MiddlewareOption) (*Manager, http.Handler) {
+	t.Helper()
+	m, err := NewManager(2, 1, time.Minute, time.Minute, time.Hour, WithPolicyName("api"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(m.Close)
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	return m, m.Middleware(func(*http.Request) string { return "k" }, opts...)(ok)
+}
+
+func TestMiddlewareLegacyHeadersByDefault(t *testing.T) {
+	_, handler := newHeaderTestHandler(t)
+
+	before := time.Now().Unix()
+	rec := serveOnce(t, handler)
+	if rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Fatalf("unexpected legacy headers %v", rec.Header())
+	}
+	reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || reset < before+60 || reset > time.Now().Unix()+60 {
+		t.Fatalf("expected X-RateLimit-Reset about a minute from now, got %q", rec.Header().Get("X-RateLimit-Reset"))
+	}
+	if rec.Header().Get("RateLimit") != "" || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Fatal("expected no IETF headers by default")
+	}
+}
+
+func TestMiddlewareDraftHeaders(t *testing.T) {
+	_, handler := newHeaderTestHandler(t, WithHeaderFormat(HeadersDraft))
+
+	serveOnce(t, handler)
+	rec := serveOnce(t, handler)
+	if got := []string{rec.Header().Get("RateLimit-Limit"), rec.Header().Get("RateLimit-Remaining"), rec.Header().Get("RateLimit-Reset")}; got[0] != "2" || got[1] != "0" || got[2] != "120" {
+		t.Fatalf("unexpected draft headers %v", got)
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatal("expected legacy headers to be replaced")
+	}
+
+	rec = serveOnce(t, handler)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("RateLimit-Reset") != "60" || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected reset to match Retry-After on denial, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestMiddlewareStructuredHeaders(t *testing.T) {
+	_, handler := newHeaderTestHandler(t, WithHeaderFormat(HeadersStructured|HeadersLegacy))
+
+	rec := serveOnce(t, handler)
+	if got := rec.Header().Get("RateLimit-Policy"); got != `"api";q=2;w=120` {
+		t.Fatalf("unexpected RateLimit-Policy %q", got)
+	}
+	if got := rec.Header().Get("RateLimit"); got != `"api";r=1;t=60` {
+		t.Fatalf("unexpected RateLimit %q", got)
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Fatal("expected combined formats to send legacy headers too")
+	}
+}
+
+func TestMiddlewareHeadersNone(t *testing.T) {
+	_, handler := newHeaderTestHandler(t, WithHeaderFormat(HeadersNone))
+
+	serveOnce(t, handler)
+	serveOnce(t, handler)
+	rec := serveOnce(t, handler)
+	if rec.Header().Get("X-RateLimit-Limit") != "" || rec.Header().Get("RateLimit") != "" {
+		t.Fatalf("expected no rate limit headers, got %v", rec.Header())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After on denial")
+	}
+}
+
+func TestSFStringEscapes(t *testing.T) {
+	if got := sfString("a\"b\\c\n"); got != `"a\"b\\c"` {
+		t.Fatalf("unexpected structured field string %s", got)
+	}
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KeyLister is implemented by stores that can enumerate the keys they track.
+type KeyLister interface {
+	// CountKeys returns the number of keys with state in the store.
+	CountKeys() (int, error)
+	// ListKeys returns up to limit keys starting at cursor, and the cursor of
+	// the next page. The first page has cursor "" and the last page returns
+	// next "". Keys added or removed while paging may be missed.
+	ListKeys(cursor string, limit int) (keys []string, next string, err error)
+}
+
+// RedisScanClient is an optional extension of RedisEvalClient. When the
+// client implements it, RedisStore can enumerate keys with SCAN.
+type RedisScanClient interface {
+	Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error)
+}
+
+// prefixKeyLister lists only the keys starting with prefix, e.g. those of
+// one Router rule. Its pages can be short, or empty, before the last one.
+type prefixKeyLister struct {
+	KeyLister
+	prefix string
+}
+
+// CountKeys pages through every key in the store.
+func (l prefixKeyLister) CountKeys() (int, error) {
+	n := 0
+	cursor := ""
+	for {
+		keys, next, err := l.ListKeys(cursor, 1000)
+		if err != nil {
+			return 0, err
+		}
+		n += len(keys)
+		if next == "" {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+func (l prefixKeyLister) ListKeys(cursor string, limit int) ([]string, string, error) {
+	keys, next, err := l.KeyLister.ListKeys(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return !strings.HasPrefix(key, l.prefix) })
+	return keys, next, nil
+}
+
+func (s *MemoryStore) CountKeys() (int, error) {
+	return s.Len(), nil
+}
+
+// ListKeys pages through keys shard by shard, in key order within a shard.
+// The cursor has the form "<shard>:<last key>".
+func (s *MemoryStore) ListKeys(cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be greater than 0")
+	}
+	shard, after, err := parseMemoryCursor(cursor, len(s.shards))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	for ; shard < len(s.shards); shard, after = shard+1, "" {
+		sh := &s.shards[shard]
+		sh.mu.RLock()
+		var candidates []string
+		for key := range sh.buckets {
+			if after == "" || key > after {
+				candidates = append(candidates, key)
+			}
+		}
+		sh.mu.RUnlock()
+
+		slices.Sort(candidates)
+		for _, key := range candidates {
+			keys = append(keys, key)
+			if len(keys) == limit {
+				return keys, strconv.Itoa(shard) + ":" + key, nil
+			}
+		}
+	}
+	return keys, "", nil
+}
+
+func parseMemoryCursor(cursor string, shards int) (int, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	idx, after, ok := strings.Cut(cursor, ":")
+	shard, err := strconv.Atoi(idx)
+	if !ok || err != nil || shard < 0 || shard >= shards || after == "" {
+		return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return shard, after, nil
+}
+
+func (s *RedisStore) scanClient() (RedisScanClient, error) {
+	c, ok := s.client.(RedisScanClient)
+	if !ok {
+		return nil, fmt.Errorf("redis client does not implement Scan: %w", errors.ErrUnsupported)
+	}
+	return c, nil
+}
+
+// CountKeys scans the whole key prefix, so it is O(keys) on the Redis side.
+func (s *RedisStore) CountKeys() (int, error) {
+	n := 0
+	cursor := ""
+	for {
+		keys, next, err := s.ListKeys(cursor, 1000)
+		if err != nil {
+			return 0, err
+		}
+		n += len(keys)
+		if next == "" {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// ListKeys pages with SCAN over the store's bucket keys. As with SCAN, limit
+// is a hint, and a key may be returned more than once across pages.
+func (s *RedisStore) ListKeys(cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be greater than 0")
+	}
+	c, err := s.scanClient()
+	if err != nil {
+		return nil, "", err
+	}
+	var pos uint64
+	if cursor != "" {
+		if pos, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+	}
+
+	raw, next, err := c.Scan(context.Background(), pos, s.scanPattern("}"), int64(limit))
+	if err != nil {
+		return nil, "", err
+	}
+	keys := make([]string, 0, len(raw))
+	for _, k := range raw {
+		keys = append(keys, s.unwrapKey(k, "}"))
+	}
+	if next == 0 {
+		return keys, "", nil
+	}
+	return keys, strconv.FormatUint(next, 10), nil
+}
+
+// scanPattern matches the Redis keys of the store ending in suffix: "}"
+// for buckets, "}:list" for access lists and "}:ban" for bans.
+func (s *RedisStore) scanPattern(suffix string) string {
+	return escapeRedisGlob(s.prefix+"{") + "*" + escapeRedisGlob(suffix)
+}
+
+// unwrapKey returns the key whose Redis key, matched by scanPattern(suffix),
+// is redisKey.
+func (s *RedisStore) unwrapKey(redisKey, suffix string) string {
+	return redisTagUnescaper.Replace(strings.TrimSuffix(strings.TrimPrefix(redisKey, s.prefix+"{"), suffix))
+}
+
+// scanSuffix calls fn with every key that has a Redis key ending in
+// "}"+suffix, along with that Redis key.
+func (s *RedisStore) scanSuffix(suffix string, fn func(key, redisKey string) error) error {
+	c, err := s.scanClient()
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		keys, next, err := c.Scan(context.Background(), cursor, s.scanPattern("}"+suffix), 100)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := fn(s.unwrapKey(k, "}"+suffix), k); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func escapeRedisGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
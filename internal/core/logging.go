@@ -0,0 +1,129 @@
+package core
+
+import (
+	"log/slog"
+	"time"
+)
+
+const defaultDenialLogRate = 10
+
+// WithLogger makes the Manager log store failures, cleanup results,
+// failovers and denials to logger. Per-request messages (denials and store
+// errors) are sampled; see WithDenialLogRate.
+func WithLogger(logger *slog.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// WithDenialLogRate caps how many denials and how many store errors are
+// logged per second, each. Defaults to 10.
+func WithDenialLogRate(perSecond int64) ManagerOption {
+	return func(m *Manager) {
+		if perSecond > 0 {
+			m.denialLogRate = perSecond
+		}
+	}
+}
+
+// logSampler lets through a bounded number of log records per second.
+type logSampler struct {
+	bucket *TokenBucket
+}
+
+func newLogSampler(perSecond int64) *logSampler {
+	bucket, _ := NewTokenBucket(perSecond, perSecond, time.Second)
+	return &logSampler{bucket: bucket}
+}
+
+func (s *logSampler) allow() bool {
+	return s.bucket.Allow()
+}
+
+// managerLogger wraps the configured logger with the Manager's policy and
+// samplers. Its methods are no-ops when no logger is configured.
+type managerLogger struct {
+	logger   *slog.Logger
+	denials  *logSampler
+	failures *logSampler
+}
+
+func newManagerLogger(logger *slog.Logger, policy string, perSecond int64) *managerLogger {
+	if logger == nil {
+		return &managerLogger{}
+	}
+	return &managerLogger{
+		logger:   logger.With(slog.String("policy", policy)),
+		denials:  newLogSampler(perSecond),
+		failures: newLogSampler(perSecond),
+	}
+}
+
+func (l *managerLogger) denial(key string, d Decision, dryRun bool) {
+	if l.logger == nil || !l.denials.allow() {
+		return
+	}
+	l.logger.Info("rate limit exceeded",
+		slog.String("key_hash", keyHash(key)),
+		slog.Int64("limit", d.Limit),
+		slog.Duration("retry_after", d.RetryAfter),
+		slog.Bool("dry_run", dryRun),
+	)
+}
+
+func (l *managerLogger) storeError(key string, err error) {
+	if l.logger == nil || !l.failures.allow() {
+		return
+	}
+	l.logger.Error("rate limiter store error",
+		slog.String("key_hash", keyHash(key)),
+		slog.Any("error", err),
+	)
+}
+
+func (l *managerLogger) ban(b Ban) {
+	if l.logger == nil {
+		return
+	}
+	l.logger.Warn("rate limiter key banned",
+		slog.String("key_hash", keyHash(b.Key)),
+		slog.Int("level", b.Level),
+		slog.Time("until", b.Until),
+	)
+}
+
+func (l *managerLogger) cleanup(e CleanupEvent) {
+	if l.logger == nil {
+		return
+	}
+	if e.Err != nil {
+		l.logger.Error("rate limiter cleanup failed",
+			slog.Time("cutoff", e.Cutoff),
+			slog.Duration("duration", e.Duration),
+			slog.Any("error", e.Err),
+		)
+		return
+	}
+	l.logger.Debug("rate limiter cleanup finished",
+		slog.Time("cutoff", e.Cutoff),
+		slog.Duration("duration", e.Duration),
+	)
+}
+
+func (l *managerLogger) failover(e FailoverEvent) {
+	if l.logger == nil {
+		return
+	}
+	l.logger.Warn("rate limiter store failover",
+		slog.String("key_hash", keyHash(e.Key)),
+		slog.String("peer", e.Peer),
+		slog.Any("error", e.Err),
+	)
+}
+
+func (l *managerLogger) failure(msg string, err error) {
+	if l.logger == nil || err == nil {
+		return
+	}
+	l.logger.Error(msg, slog.Any("error", err))
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects JSON log records.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+func (b *logBuffer) withMessage(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, rec := range b.records(t) {
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+func newTestLogger(buf *logBuffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+type closeErrorStore struct {
+	allowOnlyStore
+}
+
+func (closeErrorStore) Close() error { return errors.New("close failed") }
+
+func TestManagerLogsSampledDenials(t *testing.T) {
+	var buf logBuffer
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour,
+		WithLogger(newTestLogger(&buf)), WithDenialLogRate(2), WithPolicyName("login"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	for range 6 {
+		m.Allow("10.0.0.1")
+	}
+
+	denials := buf.withMessage(t, "rate limit exceeded")
+	if len(denials) != 2 {
+		t.Fatalf("expected 2 sampled denial logs, got %d", len(denials))
+	}
+	if d := denials[0]; d["policy"] != "login" || d["key_hash"] != keyHash("10.0.0.1") || d["limit"] != float64(1) {
+		t.Fatalf("unexpected denial log %+v", d)
+	}
+}
+
+func TestManagerLogsStoreFailures(t *testing.T) {
+	var buf logBuffer
+	store, err := NewRedisStore(FailingRedisEvalClient{}, RedisStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(store, 1, 1, time.Hour, time.Minute, time.Hour, WithLogger(newTestLogger(&buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	m.Allow("a")
+	errs := buf.withMessage(t, "rate limiter store error")
+	if len(errs) != 1 || errs[0]["level"] != "ERROR" || errs[0]["error"] != "redis unavailable" || errs[0]["policy"] != defaultPolicyName {
+		t.Fatalf("unexpected store error logs %+v", errs)
+	}
+
+	m.Cleanup()
+	if logs := buf.withMessage(t, "rate limiter cleanup finished"); len(logs) != 1 || logs[0]["level"] != "DEBUG" {
+		t.Fatalf("expected a cleanup log, got %+v", logs)
+	}
+}
+
+func TestManagerLogsCloseFailure(t *testing.T) {
+	var buf logBuffer
+	m, err := NewManagerWithStore(closeErrorStore{}, 1, 1, time.Hour, time.Minute, time.Hour, WithLogger(newTestLogger(&buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Stop()
+
+	if logs := buf.withMessage(t, "rate limiter store close failed"); len(logs) != 1 || logs[0]["error"] != "close failed" {
+		t.Fatalf("expected the close error to be logged, got %+v", buf.records(t))
+	}
+}
+
+func TestManagerLogsFailover(t *testing.T) {
+	var buf logBuffer
+	peers := startTestPeers(t, 2)
+	m, err := NewManagerWithStore(peers[0].store, 1, 1, time.Hour, time.Minute, time.Hour, WithLogger(newTestLogger(&buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	key := ownedBy(t, peers[0].store, peers[1].server.URL)
+	peers[1].server.Close()
+	m.Allow(key)
+
+	logs := buf.withMessage(t, "rate limiter store failover")
+	if len(logs) != 1 || logs[0]["peer"] != peers[1].server.URL || logs[0]["level"] != "WARN" {
+		t.Fatalf("unexpected failover logs %+v", logs)
+	}
+}
@@ -1,11 +1,17 @@
 package core
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ManagerOption configures optional Manager behaviour.
+type ManagerOption func(*Manager)
+
 type Manager struct {
 	store Store
 
@@ -16,6 +22,45 @@ type Manager struct {
 	bucketTTL       time.Duration
 	cleanupInterval time.Duration
 
+	policy       string
+	snapshotPath string
+	denials      *denialTracker
+
+	metricsRegistry *Metrics
+	metrics         *policyMetrics
+	observers       []Observer
+	tracer          Tracer
+	logger          *slog.Logger
+	denialLogRate   int64
+	log             *managerLogger
+	dryRun          bool
+	shadow          *shadowPolicy
+	shadowStore     Store
+	bans            *BanPolicy
+	// unsubscribeFailover unregisters the Manager from its store's
+	// failovers on Stop.
+	unsubscribeFailover func()
+	// sharedStore is set when the store belongs to a Router, which cleans
+	// it up in one loop for all of its Managers and closes it once after
+	// stopping them. keyPrefix then scopes key listing to the Manager's
+	// rule.
+	sharedStore bool
+	keyPrefix   string
+
+	// overrides is a copy-on-write map so AllowDecision reads it without
+	// locking; overridesMu serializes writers.
+	overrides   atomic.Pointer[map[string]Override]
+	overridesMu sync.Mutex
+
+	// trackedKeys and throttledKeys are the counts from the last cleanup
+	// pass, or -1 if the store has not been counted; keysCountedAt is when
+	// they were taken, in Unix nanoseconds. Cleanup only counts once the
+	// metrics or the admin handler need them, as keyStats records.
+	trackedKeys   atomic.Int64
+	throttledKeys atomic.Int64
+	keysCountedAt atomic.Int64
+	keyStats      atomic.Bool
+
 	stopCh   chan struct{}
 	wg       sync.WaitGroup
 	stopOnce sync.Once
@@ -27,6 +72,7 @@ func NewManager(
 	per time.Duration,
 	bucketTTL time.Duration,
 	cleanupInterval time.Duration,
+	opts ...ManagerOption,
 ) (*Manager, error) {
 	return NewManagerWithStore(
 		NewMemoryStore(),
@@ -35,6 +81,7 @@ func NewManager(
 		per,
 		bucketTTL,
 		cleanupInterval,
+		opts...,
 	)
 }
 
@@ -45,6 +92,7 @@ func NewManagerWithStore(
 	per time.Duration,
 	bucketTTL time.Duration,
 	cleanupInterval time.Duration,
+	opts ...ManagerOption,
 ) (*Manager, error) {
 	if store == nil {
 		return nil, errors.New("store cannot be nil")
@@ -67,10 +115,49 @@ func NewManagerWithStore(
 		bucketTTL:       bucketTTL,
 		cleanupInterval: cleanupInterval,
 		stopCh:          make(chan struct{}),
+		denials:         newDenialTracker(defaultDenialWindow),
+		policy:          defaultPolicyName,
+		denialLogRate:   defaultDenialLogRate,
+	}
+	m.trackedKeys.Store(-1)
+	m.throttledKeys.Store(-1)
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.log = newManagerLogger(m.logger, m.policy, m.denialLogRate)
+	if m.shadowStore != nil && m.shadow == nil {
+		return nil, errors.New("shadow store set without a shadow policy")
+	}
+	if m.shadow != nil {
+		if err := m.shadow.init(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.bans != nil {
+		if err := m.initBans(); err != nil {
+			return nil, err
+		}
 	}
 
-	m.wg.Add(1)
-	go m.cleanupLoop()
+	if m.snapshotPath != "" {
+		if err := m.restoreSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+	if m.metricsRegistry != nil {
+		m.metrics = m.metricsRegistry.register(m.policy, m)
+		if m.shadow != nil {
+			m.shadow.metrics = m.metricsRegistry.register(m.shadow.name, nil)
+		}
+	}
+	if n, ok := store.(FailoverNotifier); ok && (len(m.observers) > 0 || m.logger != nil) {
+		m.unsubscribeFailover = n.OnFailover(m.handleFailover)
+	}
+
+	if !m.sharedStore {
+		m.wg.Add(1)
+		go m.cleanupLoop()
+	}
 	return m, nil
 }
 
@@ -83,11 +170,171 @@ func (m *Manager) Allow(key string) bool {
 }
 
 func (m *Manager) AllowDecision(key string) (Decision, error) {
-	return m.store.Allow(key, BucketConfig{
+	return m.AllowDecisionContext(context.Background(), key)
+}
+
+// AllowDecisionContext is AllowDecision with a context, used for tracing
+// and passed to stores that implement ContextStore.
+func (m *Manager) AllowDecisionContext(ctx context.Context, key string) (Decision, error) {
+	return m.decide(ctx, key, m.dryRun)
+}
+
+// decide evaluates the live policy for key, and the shadow policy if one
+// is configured. With dryRun the live decision is reported but not
+// enforced.
+func (m *Manager) decide(ctx context.Context, key string, dryRun bool) (Decision, error) {
+	decision, err := m.limit(ctx, key, dryRun)
+	if err != nil || !dryRun {
+		return decision, err
+	}
+
+	decision.DryRun = true
+	decision.WouldDeny = !decision.Allowed
+	decision.Allowed = true
+	return decision, nil
+}
+
+// limit decides for key from its access list, its ban and its bucket, in
+// that order. Listed keys skip the shadow policy as well.
+func (m *Manager) limit(ctx context.Context, key string, dryRun bool) (Decision, error) {
+	decision, err := m.evaluate(ctx, evaluation{
+		policy:  m.policy,
+		key:     key,
+		store:   m.store,
+		cfg:     m.configFor(key),
+		dryRun:  dryRun,
+		guarded: true,
+		metrics: m.metrics,
+		log:     m.log,
+	})
+	if m.shadow != nil && decision.Access == AccessNone {
+		m.evaluateShadow(ctx, key)
+	}
+	return decision, err
+}
+
+// evaluation is one policy decision together with where it is reported.
+type evaluation struct {
+	policy  string
+	key     string
+	store   Store
+	cfg     BucketConfig
+	dryRun  bool
+	shadow  bool
+	metrics *policyMetrics
+	log     *managerLogger
+	// guarded checks the key's access list and ban before its bucket. Only
+	// the live policy is guarded.
+	guarded bool
+}
+
+// evaluate asks the store for a decision and reports it to tracing,
+// metrics, logs and observers. The returned decision is the store's, even
+// in dry-run mode.
+func (m *Manager) evaluate(ctx context.Context, e evaluation) (Decision, error) {
+	var span Span
+	if m.tracer != nil {
+		ctx, span = m.tracer.Start(ctx, "ratelimiter.allow")
+		defer span.End()
+	}
+
+	start := time.Now()
+	decision, err := m.allow(ctx, e)
+	elapsed := time.Since(start)
+
+	switch {
+	case err != nil:
+		e.log.storeError(e.key, err)
+	case !decision.Allowed:
+		if !e.shadow {
+			m.denials.record(e.key, start)
+		}
+		e.log.denial(e.key, decision, e.dryRun)
+	}
+	if span != nil {
+		span.SetAttributes(
+			Attribute{Key: "ratelimiter.policy", Value: e.policy},
+			Attribute{Key: "ratelimiter.key_hash", Value: keyHash(e.key)},
+			Attribute{Key: "ratelimiter.dry_run", Value: e.dryRun},
+			latencyAttribute("ratelimiter.store_latency_ms", elapsed),
+		)
+		if err != nil {
+			span.RecordError(err)
+		} else {
+			span.SetAttributes(decisionAttributes(decision)...)
+		}
+	}
+	if e.metrics != nil {
+		e.metrics.observeDecision(decision, err, elapsed, e.dryRun)
+	}
+	if len(m.observers) > 0 {
+		m.notifyDecision(DecisionEvent{
+			Policy:   e.policy,
+			Key:      e.key,
+			Decision: decision,
+			Err:      err,
+			Latency:  elapsed,
+			Time:     start,
+			DryRun:   e.dryRun,
+			Shadow:   e.shadow,
+		})
+	}
+	return decision, err
+}
+
+// allow takes a token for e. For a guarded evaluation, listed and banned
+// keys are decided without their bucket and denials count towards bans.
+// Guarded stores skip the checks while no lists or bans are in use.
+func (m *Manager) allow(ctx context.Context, e evaluation) (Decision, error) {
+	if !e.guarded {
+		return storeAllow(ctx, e.store, e.key, e.cfg)
+	}
+	if s, ok := m.store.(guardedStore); ok {
+		g := allowGuard{
+			lists:         s.checksAccessLists(),
+			bans:          m.bans,
+			recordDenials: m.bans != nil && !e.dryRun,
+		}
+		if !g.lists && g.bans == nil {
+			return storeAllow(ctx, e.store, e.key, e.cfg)
+		}
+		decision, ban, err := s.allowGuarded(ctx, e.key, e.cfg, g)
+		if !ban.Until.IsZero() {
+			m.log.ban(ban)
+		}
+		return decision, err
+	}
+
+	list, err := m.checkAccess(e.key)
+	if err != nil || list != AccessNone {
+		return listedDecision(list), err
+	}
+	if m.bans != nil {
+		decision, banned, err := m.checkBan(e.key)
+		if err != nil || banned {
+			return decision, err
+		}
+	}
+	decision, err := storeAllow(ctx, e.store, e.key, e.cfg)
+	if err == nil && !decision.Allowed && m.bans != nil && !e.dryRun {
+		decision = m.recordDenial(e.key, decision)
+	}
+	return decision, err
+}
+
+func storeAllow(ctx context.Context, store Store, key string, cfg BucketConfig) (Decision, error) {
+	if s, ok := store.(ContextStore); ok {
+		return s.AllowContext(ctx, key, cfg)
+	}
+	return store.Allow(key, cfg)
+}
+
+func (m *Manager) bucketConfig() BucketConfig {
+	return BucketConfig{
 		Capacity:   m.capacity,
 		RefillRate: m.refillRate,
 		Interval:   m.interval,
-	})
+	}
 }
 
 func (m *Manager) cleanupLoop() {
@@ -110,7 +357,21 @@ func (m *Manager) Stop() {
 	m.stopOnce.Do(func() {
 		close(m.stopCh)
 		m.wg.Wait()
-		_ = m.store.Close()
+		if m.unsubscribeFailover != nil {
+			m.unsubscribeFailover()
+		}
+		if m.snapshotPath != "" {
+			m.log.failure("rate limiter snapshot save failed", m.saveSnapshot())
+		}
+		if !m.sharedStore {
+			m.log.failure("rate limiter store close failed", m.store.Close())
+		}
+		if m.shadow != nil {
+			m.log.failure("rate limiter shadow store close failed", m.shadow.store.Close())
+		}
+		if m.metricsRegistry != nil {
+			m.metricsRegistry.unregister(m.policy, m)
+		}
 	})
 }
 
@@ -119,6 +380,30 @@ func (m *Manager) Close() {
 }
 
 func (m *Manager) Cleanup() {
-	cutoff := time.Now().Add(-m.bucketTTL)
-	_ = m.store.DeleteInactiveBuckets(cutoff)
+	now := time.Now()
+	cutoff := now.Add(-m.bucketTTL)
+	err := m.store.DeleteInactiveBuckets(cutoff)
+	m.finishCleanup(now, cutoff, err)
+}
+
+// finishCleanup does the Manager's share of a cleanup pass started at now,
+// once the store's buckets inactive since cutoff were deleted with err,
+// and reports the pass.
+func (m *Manager) finishCleanup(now, cutoff time.Time, err error) {
+	m.pruneOverrides(now)
+	if m.shadow != nil {
+		m.log.failure("rate limiter shadow cleanup failed", m.shadow.store.DeleteInactiveBuckets(cutoff))
+	}
+	elapsed := time.Since(now)
+	if m.metrics != nil {
+		m.metrics.cleanup.observe(elapsed)
+	}
+	if m.metrics != nil || m.keyStats.Load() {
+		m.countKeys()
+	}
+	e := CleanupEvent{Policy: m.policy, Cutoff: cutoff, Duration: elapsed, Err: err}
+	m.log.cleanup(e)
+	if len(m.observers) > 0 {
+		m.notifyCleanup(e)
+	}
 }
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WithSnapshotFile makes the Manager restore its store from path on startup
+// and save it back to path when stopped. The store must implement
+// Snapshotter. A missing file on startup is not an error.
+func WithSnapshotFile(path string) ManagerOption {
+	return func(m *Manager) {
+		m.snapshotPath = path
+	}
+}
+
+func (m *Manager) snapshotter() (Snapshotter, error) {
+	s, ok := m.store.(Snapshotter)
+	if !ok {
+		return nil, errors.New("store does not support snapshots")
+	}
+	return s, nil
+}
+
+func (m *Manager) restoreSnapshot() error {
+	s, err := m.snapshotter()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(m.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	if err := s.Restore(f); err != nil {
+		return fmt.Errorf("restore snapshot %s: %w", m.snapshotPath, err)
+	}
+	return nil
+}
+
+// saveSnapshot writes the snapshot to a temporary file first so a crash
+// mid-write never leaves a truncated snapshot behind.
+func (m *Manager) saveSnapshot() error {
+	s, err := m.snapshotter()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.snapshotPath), filepath.Base(m.snapshotPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), m.snapshotPath)
+}
@@ -1,53 +1,427 @@
 package core
 
 import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultMemoryStoreShards = 64
+	// minKeysPerShard caps the shard count of a bounded store at MaxKeys/16.
+	minKeysPerShard = 16
+)
+
+// EvictionPolicy decides what a bounded MemoryStore does when a new key
+// arrives and its shard is already full.
+type EvictionPolicy int
+
+const (
+	// EvictLeastRecentlyUsed drops the least recently used key of the shard
+	// to make room for the new one.
+	EvictLeastRecentlyUsed EvictionPolicy = iota
+	// RejectNewKeys keeps existing keys and denies requests for new ones
+	// until cleanup frees space.
+	RejectNewKeys
+)
+
+type MemoryStoreOptions struct {
+	// Now overrides the clock used for refills and last-seen tracking.
+	// Defaults to time.Now.
+	Now func() time.Time
+	// Shards is the number of independently locked partitions keys are
+	// hashed into. It is rounded up to a power of two. Defaults to 64.
+	Shards int
+	// MaxKeys bounds the number of tracked keys across all shards. A bounded
+	// store uses at most one shard per 16 keys, and evicts from the shard a
+	// new key hashes to, so eviction order approximates least recently used
+	// store-wide. Zero means unbounded.
+	MaxKeys int
+	// EvictionPolicy applies once MaxKeys is reached. Defaults to
+	// EvictLeastRecentlyUsed.
+	EvictionPolicy EvictionPolicy
+}
+
 type MemoryStore struct {
-	mu      sync.Mutex
-	buckets map[string]*TokenBucket
+	epoch  time.Time
+	seed   maphash.Seed
+	shards []memoryShard
+	mask   uint64
+	now    func() time.Time
+	policy EvictionPolicy
+
+	// maxKeys is zero for an unbounded store; keys counts the tracked keys
+	// of a bounded one.
+	maxKeys int64
+	keys    atomic.Int64
+
+	evictions  atomic.Uint64
+	rejections atomic.Uint64
+
+	// lists is a copy-on-write map so Allow reads it without locking;
+	// listsMu serializes writers.
+	lists   atomic.Pointer[map[string]AccessList]
+	listsMu sync.Mutex
+}
+
+type memoryShard struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryEntry
+
+	// lru orders entries from most to least recently used, and keys points
+	// at the store's key count. Both are only set when the store is bounded.
+	lru  *list.List
+	keys *atomic.Int64
+
+	expiry expiryHeap
+
+	// bans holds the ban state of the shard's keys, which outlives their
+	// buckets.
+	bans map[string]*memoryBan
+}
+
+type memoryEntry struct {
+	bucket packedBucket
+	key    string
+	elem   *list.Element
+
+	// expireAt is the last-seen time, in nanoseconds since the store epoch,
+	// recorded in the expiry index.
+	expireAt  int64
+	heapIndex int
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
-		buckets: make(map[string]*TokenBucket),
+	return NewMemoryStoreWithOptions(MemoryStoreOptions{})
+}
+
+func NewMemoryStoreWithOptions(opts MemoryStoreOptions) *MemoryStore {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	n := opts.Shards
+	if n <= 0 {
+		n = defaultMemoryStoreShards
 	}
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	if opts.MaxKeys > 0 {
+		// Keep enough keys per shard that evicting a shard's least
+		// recently used key stays close to evicting the store's.
+		for size > 1 && size*minKeysPerShard > opts.MaxKeys {
+			size >>= 1
+		}
+	}
+
+	s := &MemoryStore{
+		epoch:  now(),
+		seed:   maphash.MakeSeed(),
+		shards: make([]memoryShard, size),
+		mask:   uint64(size - 1),
+		now:    now,
+		policy: opts.EvictionPolicy,
+	}
+	if opts.MaxKeys > 0 {
+		s.maxKeys = int64(opts.MaxKeys)
+	}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*memoryEntry)
+		if s.maxKeys > 0 {
+			s.shards[i].lru = list.New()
+			s.shards[i].keys = &s.keys
+		}
+	}
+	return s
+}
+
+func (s *MemoryStore) shard(key string) *memoryShard {
+	return &s.shards[maphash.String(s.seed, key)&s.mask]
+}
+
+// nowNanos returns the current time in nanoseconds since the store epoch.
+func (s *MemoryStore) nowNanos() int64 {
+	return int64(s.now().Sub(s.epoch))
 }
 
 func (s *MemoryStore) Allow(key string, cfg BucketConfig) (Decision, error) {
-	s.mu.Lock()
-	tb, ok := s.buckets[key]
-	if !ok {
-		var err error
-		tb, err = NewTokenBucket(cfg.Capacity, cfg.RefillRate, cfg.Interval)
-		if err != nil {
-			s.mu.Unlock()
+	now := s.nowNanos()
+	e, err := s.entry(key, cfg, now)
+	if err != nil {
+		return Decision{}, err
+	}
+	return s.allowEntry(e, cfg, now), nil
+}
+
+// allowEntry takes a token from e, which is nil if the key was rejected.
+func (s *MemoryStore) allowEntry(e *memoryEntry, cfg BucketConfig, now int64) Decision {
+	if e == nil {
+		s.rejections.Add(1)
+		return Decision{
+			Limit:      cfg.Capacity,
+			RetryAfter: retryAfterForConfig(cfg, false),
+		}
+	}
+	return e.bucket.allow(now)
+}
+
+// entry returns the entry for key, creating it from cfg if needed. A bucket
+// created with a different config is replaced, keeping its tokens up to the
+// new capacity, so config changes apply to existing keys. It returns a nil
+// entry and no error when the key is new and the RejectNewKeys policy keeps
+// it out.
+func (s *MemoryStore) entry(key string, cfg BucketConfig, now int64) (*memoryEntry, error) {
+	sh := s.shard(key)
+
+	if sh.lru == nil {
+		sh.mu.RLock()
+		e := sh.buckets[key]
+		sh.mu.RUnlock()
+		if e != nil && e.bucket.matches(cfg) {
+			return e, nil
+		}
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return s.lockedEntry(sh, key, cfg, now)
+}
+
+// lockedEntry is entry. The caller must hold sh.mu, the shard of key.
+func (s *MemoryStore) lockedEntry(sh *memoryShard, key string, cfg BucketConfig, now int64) (*memoryEntry, error) {
+	old, ok := sh.buckets[key]
+	if ok && old.bucket.matches(cfg) {
+		if sh.lru != nil {
+			sh.lru.MoveToFront(old.elem)
+		}
+		return old, nil
+	}
+
+	e := &memoryEntry{key: key, expireAt: now}
+	if err := e.bucket.init(cfg, now); err != nil {
+		return nil, err
+	}
+	if ok {
+		tokens, _ := old.bucket.state(now)
+		e.bucket.fill(tokens, now)
+		sh.remove(old)
+	}
+	if !s.add(sh, e) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Peek reports the bucket for key without consuming a token or refreshing
+// its last-seen time.
+func (s *MemoryStore) Peek(key string, cfg BucketConfig) (Decision, error) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	e := sh.buckets[key]
+	sh.mu.RUnlock()
+
+	if e == nil {
+		if err := validateTokenBucketConfig(cfg.Capacity, cfg.RefillRate, cfg.Interval); err != nil {
 			return Decision{}, err
 		}
-		s.buckets[key] = tb
+		return Decision{Allowed: true, Limit: cfg.Capacity, Remaining: cfg.Capacity}, nil
 	}
-	s.mu.Unlock()
+	return e.bucket.peek(s.nowNanos()), nil
+}
+
+// Reset refills the bucket for key to capacity. Unknown keys are left alone,
+// since a new bucket starts full anyway.
+func (s *MemoryStore) Reset(key string) error {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	e := sh.buckets[key]
+	sh.mu.RUnlock()
 
-	return tb.allowDecision(), nil
+	if e != nil {
+		e.bucket.fill(e.bucket.capacity, s.nowNanos())
+	}
+	return nil
 }
 
-func (s *MemoryStore) DeleteInactiveBuckets(cutoff time.Time) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStore) Delete(key string) error {
+	s.deleteKey(key)
+	return nil
+}
 
-	for key, bucket := range s.buckets {
-		bucket.mu.Lock()
-		lastSeen := bucket.lastSeen
-		bucket.mu.Unlock()
-		if lastSeen.Before(cutoff) {
-			delete(s.buckets, key)
+// deleteKey removes a single bucket, if present.
+func (s *MemoryStore) deleteKey(key string) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if e, ok := sh.buckets[key]; ok {
+		sh.remove(e)
+	}
+}
+
+func (s *MemoryStore) Set(key string, cfg BucketConfig, tokens int64) error {
+	now := s.nowNanos()
+	e, err := s.entry(key, cfg, now)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return errors.New("memory store is full")
+	}
+	e.bucket.fill(tokens, now)
+	e.bucket.lastSeen.Store(now)
+	return nil
+}
+
+// add indexes a new entry. A bounded store first reserves room for it,
+// evicting least recently used entries while the store is full; add reports
+// false and leaves the entry out if the RejectNewKeys policy applies or
+// nothing could be evicted. The caller must hold sh.mu.
+func (s *MemoryStore) add(sh *memoryShard, e *memoryEntry) bool {
+	if sh.lru != nil {
+		for !s.reserve() {
+			if s.policy == RejectNewKeys || !s.evict(sh) {
+				return false
+			}
+		}
+		e.elem = sh.lru.PushFront(e)
+	}
+	sh.buckets[e.key] = e
+	heap.Push(&sh.expiry, e)
+	return true
+}
+
+// reserve counts one more key against MaxKeys, unless the store is full.
+func (s *MemoryStore) reserve() bool {
+	for {
+		n := s.keys.Load()
+		if n >= s.maxKeys {
+			return false
+		}
+		if s.keys.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// evict drops the least recently used entry of sh or, if sh is empty, of
+// another shard. Other shards are only tried with TryLock, since the caller
+// holds sh.mu and waiting for another shard could deadlock. The caller must
+// hold sh.mu.
+func (s *MemoryStore) evict(sh *memoryShard) bool {
+	if sh.evictOldest() {
+		s.evictions.Add(1)
+		return true
+	}
+	for i := range s.shards {
+		other := &s.shards[i]
+		if other == sh || !other.mu.TryLock() {
+			continue
+		}
+		ok := other.evictOldest()
+		other.mu.Unlock()
+		if ok {
+			s.evictions.Add(1)
+			return true
 		}
 	}
+	return false
+}
+
+// evictOldest removes the shard's least recently used entry, if any. The
+// caller must hold sh.mu.
+func (sh *memoryShard) evictOldest() bool {
+	back := sh.lru.Back()
+	if back == nil {
+		return false
+	}
+	sh.remove(back.Value.(*memoryEntry))
+	return true
+}
+
+// Len returns the number of tracked keys.
+func (s *MemoryStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.buckets)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Evictions returns how many keys have been dropped to stay within MaxKeys.
+func (s *MemoryStore) Evictions() uint64 {
+	return s.evictions.Load()
+}
+
+// Rejections returns how many requests for new keys were denied because the
+// store was full under the RejectNewKeys policy.
+func (s *MemoryStore) Rejections() uint64 {
+	return s.rejections.Load()
+}
+
+// DeleteInactiveBuckets removes buckets not used since cutoff. Shards are
+// swept one at a time so requests for keys in other shards are not blocked,
+// and each shard only visits entries its expiry index marks as candidates.
+func (s *MemoryStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	s.deleteInactive(cutoff, nil)
+	s.pruneBans()
 	return nil
 }
 
+// deleteInactive removes buckets not used since cutoff and reports each
+// removed key to onDelete, if set.
+func (s *MemoryStore) deleteInactive(cutoff time.Time, onDelete func(key string)) {
+	c := int64(cutoff.Sub(s.epoch))
+	for i := range s.shards {
+		s.shards[i].deleteInactive(c, onDelete)
+	}
+}
+
+func (sh *memoryShard) deleteInactive(cutoff int64, onDelete func(key string)) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	for {
+		e, expireAt, ok := sh.expiry.peek()
+		if !ok || expireAt >= cutoff {
+			return
+		}
+
+		lastSeen := e.bucket.lastSeen.Load()
+		if lastSeen < cutoff {
+			sh.remove(e)
+			if onDelete != nil {
+				onDelete(e.key)
+			}
+			continue
+		}
+		// Used since it was indexed: re-index at its real last-seen time.
+		e.expireAt = lastSeen
+		heap.Fix(&sh.expiry, e.heapIndex)
+	}
+}
+
+// remove drops e from the shard. The caller must hold sh.mu.
+func (sh *memoryShard) remove(e *memoryEntry) {
+	delete(sh.buckets, e.key)
+	if sh.lru != nil {
+		sh.lru.Remove(e.elem)
+		sh.keys.Add(-1)
+	}
+	if e.heapIndex >= 0 {
+		heap.Remove(&sh.expiry, e.heapIndex)
+	}
+}
+
 func (s *MemoryStore) Close() error {
 	return nil
 }
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreShardCountRoundedToPowerOfTwo(t *testing.T) {
+	for _, tc := range []struct{ in, want int }{{0, defaultMemoryStoreShards}, {1, 1}, {3, 4}, {64, 64}, {100, 128}} {
+		s := NewMemoryStoreWithOptions(MemoryStoreOptions{Shards: tc.in})
+		if len(s.shards) != tc.want {
+			t.Fatalf("Shards=%d: expected %d shards, got %d", tc.in, tc.want, len(s.shards))
+		}
+	}
+}
+
+func TestMemoryStoreCleanupAcrossShards(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock, Shards: 8})
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+	for i := range 100 {
+		if _, err := s.Allow(fmt.Sprintf("key-%d", i), cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.DeleteInactiveBuckets(now.Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range s.shards {
+		if n := len(s.shards[i].buckets); n != 0 {
+			t.Fatalf("shard %d: expected no buckets after cleanup, got %d", i, n)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Shards: 1, MaxKeys: 2})
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if _, err := s.Allow(key, cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := s.Evictions(); got != 1 {
+		t.Fatalf("expected 1 eviction, got %d", got)
+	}
+	// "b" was least recently used and must have been evicted, so it starts fresh.
+	if d, _ := s.Allow("b", cfg); !d.Allowed {
+		t.Fatal("expected evicted key to get a fresh bucket")
+	}
+	// Admitting "b" evicted "a"; "c" is still tracked and exhausted.
+	if d, _ := s.Allow("c", cfg); d.Allowed {
+		t.Fatal("expected recently used key to keep its bucket")
+	}
+	if got := s.Evictions(); got != 2 {
+		t.Fatalf("expected 2 evictions, got %d", got)
+	}
+}
+
+func TestMemoryStoreRejectNewKeysWhenFull(t *testing.T) {
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Shards: 1, MaxKeys: 1, EvictionPolicy: RejectNewKeys})
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+
+	if d, _ := s.Allow("a", cfg); !d.Allowed {
+		t.Fatal("expected first key to be admitted")
+	}
+	d, err := s.Allow("b", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Allowed || d.Limit != cfg.Capacity || d.RetryAfter <= 0 {
+		t.Fatalf("expected new key to be denied with metadata, got %+v", d)
+	}
+	if s.Rejections() != 1 || s.Evictions() != 0 {
+		t.Fatalf("expected 1 rejection and no evictions, got %d and %d", s.Rejections(), s.Evictions())
+	}
+	if d, _ := s.Allow("a", cfg); !d.Allowed {
+		t.Fatal("expected existing key to keep working")
+	}
+	if err := s.DeleteInactiveBuckets(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d, _ := s.Allow("b", cfg); !d.Allowed {
+		t.Fatal("expected new key to be admitted after cleanup")
+	}
+}
+
+func TestMemoryStoreMaxKeysNeverExceeded(t *testing.T) {
+	const maxKeys = 100
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{MaxKeys: maxKeys})
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+	for i := range 10 * maxKeys {
+		if _, err := s.Allow(fmt.Sprintf("spoofed-%d", i), cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	total := 0
+	for i := range s.shards {
+		total += len(s.shards[i].buckets)
+	}
+	if total > maxKeys {
+		t.Fatalf("expected at most %d keys, got %d", maxKeys, total)
+	}
+	if s.Evictions() != uint64(10*maxKeys-total) {
+		t.Fatalf("expected %d evictions, got %d", 10*maxKeys-total, s.Evictions())
+	}
+}
+
+func TestMemoryStoreNoEvictionsBelowMaxKeys(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLeastRecentlyUsed, RejectNewKeys} {
+		s := NewMemoryStoreWithOptions(MemoryStoreOptions{MaxKeys: 100, EvictionPolicy: policy})
+		cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+
+		for i := range 100 {
+			if d, err := s.Allow(fmt.Sprintf("key-%d", i), cfg); err != nil || !d.Allowed {
+				t.Fatalf("policy %d: expected key %d to be admitted, got %+v, %v", policy, i, d, err)
+			}
+		}
+		if s.Len() != 100 || s.Evictions() != 0 || s.Rejections() != 0 {
+			t.Fatalf("policy %d: expected 100 keys and no evictions or rejections, got %d, %d and %d",
+				policy, s.Len(), s.Evictions(), s.Rejections())
+		}
+	}
+}
+
+func TestMemoryStoreCleanupUsesExpiryIndex(t *testing.T) {
+	now := time.Unix(0, 0)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock, Shards: 4, MaxKeys: 400})
+	cfg := BucketConfig{Capacity: 10, RefillRate: 1, Interval: time.Hour}
+
+	for i := range 200 {
+		_, _ = s.Allow(fmt.Sprintf("key-%d", i), cfg)
+	}
+	advance(time.Minute)
+	// Touch every other key so its indexed last-seen time is stale.
+	for i := 0; i < 200; i += 2 {
+		_, _ = s.Allow(fmt.Sprintf("key-%d", i), cfg)
+	}
+	advance(time.Minute)
+
+	if err := s.DeleteInactiveBuckets(clock().Add(-90 * time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	total := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		if len(sh.expiry) != len(sh.buckets) || sh.lru.Len() != len(sh.buckets) {
+			t.Fatalf("shard %d: index sizes diverged: map=%d heap=%d lru=%d", i, len(sh.buckets), len(sh.expiry), sh.lru.Len())
+		}
+		for key, e := range sh.buckets {
+			if sh.expiry[e.heapIndex] != e {
+				t.Fatalf("shard %d: heap index of %q is stale", i, key)
+			}
+		}
+		total += len(sh.buckets)
+	}
+	if total != 100 {
+		t.Fatalf("expected 100 recently used keys to survive, got %d", total)
+	}
+	for i := 0; i < 200; i += 2 {
+		sh := s.shard(fmt.Sprintf("key-%d", i))
+		if _, ok := sh.buckets[fmt.Sprintf("key-%d", i)]; !ok {
+			t.Fatalf("expected key-%d to survive cleanup", i)
+		}
+	}
+}
+
+func BenchmarkMemoryStoreCleanupNothingExpired(b *testing.B) {
+	s := NewMemoryStore()
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+	for i := range 100_000 {
+		_, _ = s.Allow(fmt.Sprintf("key-%d", i), cfg)
+	}
+	cutoff := time.Now().Add(-time.Hour)
+
+	b.ResetTimer()
+	for range b.N {
+		_ = s.DeleteInactiveBuckets(cutoff)
+	}
+}
+
+func TestMemoryStoreAppliesConfigChanges(t *testing.T) {
+	s := NewMemoryStore()
+	small := BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Hour}
+	large := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+
+	_, _ = s.Allow("user", small)
+	d, _ := s.Allow("user", large)
+	if !d.Allowed || d.Limit != 5 || d.Remaining != 0 {
+		t.Fatalf("expected existing tokens to carry over under the new limit, got %+v", d)
+	}
+	if d, _ := s.Allow("user", small); d.Allowed || d.Limit != 2 {
+		t.Fatalf("expected switch back to the small limit, got %+v", d)
+	}
+	if _, err := s.Allow("user", BucketConfig{}); err == nil {
+		t.Fatal("expected invalid config to be rejected for an existing key")
+	}
+}
+
+func benchmarkMemoryStoreAllow(b *testing.B, shards int, goroutines int) {
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Shards: shards})
+	cfg := BucketConfig{Capacity: 1 << 40, RefillRate: 1, Interval: time.Hour}
+
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	var next atomic.Uint64
+	runConcurrently(b, goroutines, func() {
+		_, _ = s.Allow(keys[next.Add(1)%uint64(len(keys))], cfg)
+	})
+}
+
+func BenchmarkMemoryStoreAllow(b *testing.B) {
+	for _, shards := range []int{1, defaultMemoryStoreShards} {
+		for _, goroutines := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("shards=%d/goroutines=%d", shards, goroutines), func(b *testing.B) {
+				benchmarkMemoryStoreAllow(b, shards, goroutines)
+			})
+		}
+	}
+}
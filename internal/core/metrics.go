@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPolicyName = "default"
+
+var (
+	decisionLatencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	cleanupDurationBuckets = []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Metrics collects decision, store error, latency and cleanup metrics for
+// one or more Managers, labelled by policy name, and renders them in the
+// Prometheus text exposition format. Managers sharing a policy name share
+// counters.
+type Metrics struct {
+	mu       sync.Mutex
+	policies map[string]*policyMetrics
+}
+
+type policyMetrics struct {
+	allowed      atomic.Int64
+	denied       atomic.Int64
+	dryRunDenied atomic.Int64
+	denylisted   atomic.Int64
+	banned       atomic.Int64
+	storeErrors  atomic.Int64
+	latency      *histogram
+	cleanup      *histogram
+
+	// managers feed the tracked keys gauge; guarded by Metrics.mu.
+	managers map[*Manager]struct{}
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{policies: make(map[string]*policyMetrics)}
+}
+
+// WithMetrics records the Manager's decisions, store errors, latencies,
+// tracked keys and cleanup durations in metrics. Tracked keys are counted
+// after each cleanup pass rather than on every scrape, since counting can
+// mean scanning the whole store.
+func WithMetrics(metrics *Metrics) ManagerOption {
+	return func(m *Manager) {
+		m.metricsRegistry = metrics
+	}
+}
+
+// WithPolicyName names the Manager's policy in metrics and other
+// reporting. Defaults to "default".
+func WithPolicyName(name string) ManagerOption {
+	return func(m *Manager) {
+		if name != "" {
+			m.policy = name
+		}
+	}
+}
+
+// register returns the metrics for policy. m, if not nil, contributes to
+// the policy's tracked keys gauge.
+func (mt *Metrics) register(policy string, m *Manager) *policyMetrics {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	p, ok := mt.policies[policy]
+	if !ok {
+		p = &policyMetrics{
+			latency:  newHistogram(decisionLatencyBuckets),
+			cleanup:  newHistogram(cleanupDurationBuckets),
+			managers: make(map[*Manager]struct{}),
+		}
+		mt.policies[policy] = p
+	}
+	if m != nil {
+		p.managers[m] = struct{}{}
+	}
+	return p
+}
+
+// unregister stops m contributing to the tracked keys gauge. Its counters
+// are kept.
+func (mt *Metrics) unregister(policy string, m *Manager) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	if p, ok := mt.policies[policy]; ok {
+		delete(p.managers, m)
+	}
+}
+
+func (p *policyMetrics) observeDecision(decision Decision, err error, elapsed time.Duration, dryRun bool) {
+	switch {
+	case err != nil:
+		p.storeErrors.Add(1)
+	case decision.Allowed:
+		p.allowed.Add(1)
+	case dryRun:
+		p.dryRunDenied.Add(1)
+	case decision.Access == AccessDeny:
+		p.denylisted.Add(1)
+	case !decision.BannedUntil.IsZero():
+		p.banned.Add(1)
+	default:
+		p.denied.Add(1)
+	}
+	p.latency.observe(elapsed)
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+func (mt *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = mt.Write(w)
+	})
+}
+
+// Write renders the metrics in the Prometheus text exposition format.
+func (mt *Metrics) Write(w io.Writer) error {
+	type policySnapshot struct {
+		name     string
+		p        *policyMetrics
+		managers []*Manager
+	}
+
+	mt.mu.Lock()
+	policies := make([]policySnapshot, 0, len(mt.policies))
+	for name, p := range mt.policies {
+		snap := policySnapshot{name: name, p: p}
+		for m := range p.managers {
+			snap.managers = append(snap.managers, m)
+		}
+		policies = append(policies, snap)
+	}
+	mt.mu.Unlock()
+	slices.SortFunc(policies, func(a, b policySnapshot) int { return strings.Compare(a.name, b.name) })
+
+	bw := bufio.NewWriter(w)
+
+	writeMetricHeader(bw, "ratelimiter_decisions_total", "counter", "Rate limit decisions by result; dry_run_denied counts denials that were not enforced, denylisted and banned requests denied by the access list or a ban.")
+	for _, s := range policies {
+		label := promLabel("policy", s.name)
+		fmt.Fprintf(bw, "ratelimiter_decisions_total{%s,result=\"allowed\"} %d\n", label, s.p.allowed.Load())
+		fmt.Fprintf(bw, "ratelimiter_decisions_total{%s,result=\"denied\"} %d\n", label, s.p.denied.Load())
+		fmt.Fprintf(bw, "ratelimiter_decisions_total{%s,result=\"dry_run_denied\"} %d\n", label, s.p.dryRunDenied.Load())
+		fmt.Fprintf(bw, "ratelimiter_decisions_total{%s,result=\"denylisted\"} %d\n", label, s.p.denylisted.Load())
+		fmt.Fprintf(bw, "ratelimiter_decisions_total{%s,result=\"banned\"} %d\n", label, s.p.banned.Load())
+	}
+
+	writeMetricHeader(bw, "ratelimiter_store_errors_total", "counter", "Store errors returned while making a decision.")
+	for _, s := range policies {
+		fmt.Fprintf(bw, "ratelimiter_store_errors_total{%s} %d\n", promLabel("policy", s.name), s.p.storeErrors.Load())
+	}
+
+	writeMetricHeader(bw, "ratelimiter_decision_duration_seconds", "histogram", "Time taken to make a decision, including the store call.")
+	for _, s := range policies {
+		s.p.latency.write(bw, "ratelimiter_decision_duration_seconds", promLabel("policy", s.name))
+	}
+
+	writeMetricHeader(bw, "ratelimiter_tracked_keys", "gauge", "Keys tracked by the store as of the last cleanup pass, for stores that can count them.")
+	for _, s := range policies {
+		var total int64
+		counted := false
+		for _, m := range s.managers {
+			if n := m.trackedKeys.Load(); n >= 0 {
+				total += n
+				counted = true
+			}
+		}
+		if counted {
+			fmt.Fprintf(bw, "ratelimiter_tracked_keys{%s} %d\n", promLabel("policy", s.name), total)
+		}
+	}
+
+	writeMetricHeader(bw, "ratelimiter_cleanup_duration_seconds", "histogram", "Time taken by a cleanup pass.")
+	for _, s := range policies {
+		s.p.cleanup.write(bw, "ratelimiter_cleanup_duration_seconds", promLabel("policy", s.name))
+	}
+
+	return bw.Flush()
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(name, value string) string {
+	return name + `="` + promLabelEscaper.Replace(value) + `"`
+}
+
+// histogram is a fixed-bucket histogram of durations safe for concurrent
+// use. Bucket counts are stored non-cumulatively and summed when written.
+type histogram struct {
+	bounds   []float64
+	counts   []atomic.Int64 // len(bounds)+1; the last slot is +Inf
+	sumNanos atomic.Int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sumNanos.Add(int64(d))
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatPromFloat(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatPromFloat(time.Duration(h.sumNanos.Load()).Seconds()))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+}
+
+func formatPromFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCountsDecisionsPerPolicy(t *testing.T) {
+	metrics := NewMetrics()
+	login, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithMetrics(metrics), WithPolicyName("login"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer login.Close()
+	api, err := NewManager(5, 1, time.Hour, time.Minute, time.Hour, WithMetrics(metrics), WithPolicyName(`api "v2"`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer api.Close()
+
+	login.Allow("a")
+	login.Allow("a")
+	login.Allow("b")
+	login.Cleanup()
+	api.Allow("a")
+	api.Cleanup()
+	// Keys are counted at cleanup, so "b" is not in the gauge yet.
+	api.Allow("b")
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE ratelimiter_decisions_total counter\n",
+		`ratelimiter_decisions_total{policy="login",result="allowed"} 2` + "\n",
+		`ratelimiter_decisions_total{policy="login",result="denied"} 1` + "\n",
+		`ratelimiter_decisions_total{policy="api \"v2\"",result="allowed"} 2` + "\n",
+		`ratelimiter_store_errors_total{policy="login"} 0` + "\n",
+		`ratelimiter_decision_duration_seconds_bucket{policy="login",le="+Inf"} 3` + "\n",
+		`ratelimiter_decision_duration_seconds_count{policy="login"} 3` + "\n",
+		`ratelimiter_tracked_keys{policy="login"} 2` + "\n",
+		`ratelimiter_tracked_keys{policy="api \"v2\""} 1` + "\n",
+		`ratelimiter_cleanup_duration_seconds_count{policy="api \"v2\""} 1` + "\n",
+		`ratelimiter_cleanup_duration_seconds_count{policy="login"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsCountsStoreErrors(t *testing.T) {
+	store, err := NewRedisStore(FailingRedisEvalClient{}, RedisStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metrics := NewMetrics()
+	m, err := NewManagerWithStore(store, 1, 1, time.Hour, time.Minute, time.Hour, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	m.Allow("a")
+
+	var b strings.Builder
+	if err := metrics.Write(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(b.String(), `ratelimiter_store_errors_total{policy="default"} 1`) {
+		t.Fatalf("expected store error to be counted, got:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), "ratelimiter_tracked_keys{") {
+		t.Fatal("expected no tracked keys gauge for a store that cannot count keys")
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := newHistogram([]float64{0.001, 0.01})
+	h.observe(500 * time.Microsecond)
+	h.observe(time.Millisecond)
+	h.observe(5 * time.Millisecond)
+	h.observe(time.Second)
+
+	var b strings.Builder
+	h.write(&b, "x", `policy="p"`)
+	want := `x_bucket{policy="p",le="0.001"} 2
+x_bucket{policy="p",le="0.01"} 3
+x_bucket{policy="p",le="+Inf"} 4
+x_sum{policy="p"} 1.0065
+x_count{policy="p"} 4
+`
+	if b.String() != want {
+		t.Fatalf("unexpected histogram output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
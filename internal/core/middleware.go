@@ -6,35 +6,116 @@ import (
 	"time"
 )
 
+// MiddlewareOption configures Manager.Middleware.
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	dryRun         bool
+	headers        HeaderFormat
+	problemDetails bool
+	onDenied       func(http.ResponseWriter, *http.Request, Decision)
+	onError        func(http.ResponseWriter, *http.Request, error)
+	skip           func(*http.Request) bool
+}
+
+// WithMiddlewareDryRun makes the middleware report decisions without
+// enforcing them, as WithDryRun does for the whole Manager. Responses carry
+// X-RateLimit-Dry-Run set to "allowed" or "denied".
+func WithMiddlewareDryRun() MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.dryRun = true
+	}
+}
+
+// WithSkip exempts requests for which fn returns true, e.g. health checks,
+// internal networks or admin API keys. Skipped requests consume no tokens
+// and get no rate limit headers.
+func WithSkip(fn func(*http.Request) bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.skip = fn
+	}
+}
+
 func (m *Manager) Middleware(
 	keyFunc func(*http.Request) string,
+	opts ...MiddlewareOption,
 ) func(http.Handler) http.Handler {
+	cfg := newMiddlewareConfig(opts)
+	dryRun := cfg.dryRun || m.dryRun
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := keyFunc(r)
-			decision, err := m.AllowDecision(key)
-			if err != nil {
-				http.Error(w, "rate limiter error", http.StatusInternalServerError)
+			if cfg.skip != nil && cfg.skip(r) {
+				next.ServeHTTP(w, r)
 				return
 			}
-
-			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
-			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
-			if !decision.Allowed && decision.RetryAfter > 0 {
-				w.Header().Set("Retry-After", strconv.FormatInt(durationCeilSeconds(decision.RetryAfter), 10))
-			}
-
-			if !decision.Allowed {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				_, _ = w.Write([]byte(`{"error":"rate limit exceeded"}`))
-				return
+			key := keyFunc(r)
+			decision, err := m.decide(r.Context(), key, dryRun)
+			if cfg.respond(w, r, m, key, decision, err) {
+				next.ServeHTTP(w, r)
 			}
-			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+func newMiddlewareConfig(opts []MiddlewareOption) middlewareConfig {
+	cfg := middlewareConfig{headers: HeadersLegacy}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// respond writes the headers for a decision m made for key, and the error or
+// denial response if there is one. It reports whether the request may
+// proceed.
+func (c *middlewareConfig) respond(w http.ResponseWriter, r *http.Request, m *Manager, key string, decision Decision, err error) bool {
+	if err != nil {
+		if c.onError != nil {
+			c.onError(w, r, err)
+		} else {
+			c.writeError(w, r)
+		}
+		return false
+	}
+
+	// Listed keys have no bucket to report on.
+	if c.headers != HeadersNone && decision.Access == AccessNone {
+		m.writeRateLimitHeaders(w.Header(), c.headers, decision, m.configFor(key), time.Now())
+	}
+	if !decision.Allowed && decision.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(durationCeilSeconds(decision.RetryAfter), 10))
+	}
+	if decision.DryRun {
+		result := "allowed"
+		if decision.WouldDeny {
+			result = "denied"
+		}
+		w.Header().Set("X-RateLimit-Dry-Run", result)
+	}
+
+	if (!decision.Allowed || decision.WouldDeny) && m.tracer != nil {
+		m.tracer.SpanFromContext(r.Context()).AddEvent("ratelimiter.denied",
+			Attribute{Key: "ratelimiter.policy", Value: m.policy},
+			Attribute{Key: "ratelimiter.key_hash", Value: keyHash(key)},
+			Attribute{Key: "ratelimiter.retry_after_ms", Value: decision.RetryAfter.Milliseconds()},
+			Attribute{Key: "ratelimiter.dry_run", Value: decision.DryRun},
+		)
+	}
+	if !decision.Allowed {
+		switch {
+		case c.onDenied != nil:
+			c.onDenied(w, r, decision)
+		case decision.Access == AccessDeny:
+			c.writeForbidden(w, r)
+		default:
+			c.writeDenied(w, r, decision)
+		}
+		return false
+	}
+	return true
+}
+
 func durationCeilSeconds(d time.Duration) int64 {
 	if d <= 0 {
 		return 0
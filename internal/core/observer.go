@@ -0,0 +1,195 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of Manager activity. Methods are called
+// synchronously on the goroutine that triggered the event, so they must be
+// fast and safe for concurrent use; wrap slow observers with
+// NewAsyncObserver. Embed NopObserver to implement only some methods.
+type Observer interface {
+	OnDecision(DecisionEvent)
+	OnCleanup(CleanupEvent)
+	OnFailover(FailoverEvent)
+	OnReconfigure(ReconfigureEvent)
+}
+
+// DecisionEvent describes one AllowDecision call.
+type DecisionEvent struct {
+	Policy   string
+	Key      string
+	Decision Decision
+	Err      error
+	Latency  time.Duration
+	Time     time.Time
+	// DryRun is set when the decision was not enforced; Decision.Allowed is
+	// still the computed result. Shadow is set for shadow policy decisions,
+	// which are always dry runs.
+	DryRun bool
+	Shadow bool
+}
+
+// CleanupEvent describes one cleanup pass.
+type CleanupEvent struct {
+	Policy   string
+	Cutoff   time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// FailoverEvent is reported when a store serves a key from a fallback
+// because its primary location was unavailable.
+type FailoverEvent struct {
+	Policy string
+	Key    string
+	// Peer is the unavailable owner of the key, if the store has one.
+	Peer string
+	Err  error
+	Time time.Time
+}
+
+// ReconfigureEvent is reported when a key's limit is overridden or the
+// override is removed or expires.
+type ReconfigureEvent struct {
+	Policy    string
+	Key       string
+	Config    BucketConfig
+	ExpiresAt time.Time
+	// Removed is set when the key goes back to the Manager's config.
+	Removed bool
+}
+
+// FailoverNotifier is implemented by stores that can fail over, so a
+// Manager can report failovers to its observers.
+type FailoverNotifier interface {
+	// OnFailover registers fn and returns a func that unregisters it.
+	OnFailover(fn func(FailoverEvent)) (unsubscribe func())
+}
+
+type NopObserver struct{}
+
+func (NopObserver) OnDecision(DecisionEvent)       {}
+func (NopObserver) OnCleanup(CleanupEvent)         {}
+func (NopObserver) OnFailover(FailoverEvent)       {}
+func (NopObserver) OnReconfigure(ReconfigureEvent) {}
+
+// WithObserver adds an observer to the Manager. It can be given several
+// times.
+func WithObserver(o Observer) ManagerOption {
+	return func(m *Manager) {
+		if o != nil {
+			m.observers = append(m.observers, o)
+		}
+	}
+}
+
+func (m *Manager) notifyDecision(e DecisionEvent) {
+	for _, o := range m.observers {
+		o.OnDecision(e)
+	}
+}
+
+func (m *Manager) notifyCleanup(e CleanupEvent) {
+	for _, o := range m.observers {
+		o.OnCleanup(e)
+	}
+}
+
+func (m *Manager) handleFailover(e FailoverEvent) {
+	e.Policy = m.policy
+	m.log.failover(e)
+	for _, o := range m.observers {
+		o.OnFailover(e)
+	}
+}
+
+func (m *Manager) notifyReconfigure(e ReconfigureEvent) {
+	e.Policy = m.policy
+	for _, o := range m.observers {
+		o.OnReconfigure(e)
+	}
+}
+
+// AsyncObserver delivers events to another Observer from a single
+// background goroutine, so observers never block requests. Events that
+// arrive while the buffer is full are dropped and counted.
+type AsyncObserver struct {
+	next    Observer
+	events  chan any
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewAsyncObserver starts delivering events to next. buffer defaults to
+// 1024. Call Close to flush pending events and stop the goroutine.
+func NewAsyncObserver(next Observer, buffer int) *AsyncObserver {
+	if buffer <= 0 {
+		buffer = 1024
+	}
+	a := &AsyncObserver{
+		next:   next,
+		events: make(chan any, buffer),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncObserver) run() {
+	defer close(a.done)
+	for e := range a.events {
+		switch e := e.(type) {
+		case DecisionEvent:
+			a.next.OnDecision(e)
+		case CleanupEvent:
+			a.next.OnCleanup(e)
+		case FailoverEvent:
+			a.next.OnFailover(e)
+		case ReconfigureEvent:
+			a.next.OnReconfigure(e)
+		}
+	}
+}
+
+func (a *AsyncObserver) enqueue(e any) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.events <- e:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+func (a *AsyncObserver) OnDecision(e DecisionEvent)       { a.enqueue(e) }
+func (a *AsyncObserver) OnCleanup(e CleanupEvent)         { a.enqueue(e) }
+func (a *AsyncObserver) OnFailover(e FailoverEvent)       { a.enqueue(e) }
+func (a *AsyncObserver) OnReconfigure(e ReconfigureEvent) { a.enqueue(e) }
+
+// Dropped returns the number of events dropped because the buffer was full
+// or the observer was closed.
+func (a *AsyncObserver) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Close delivers pending events and stops the delivery goroutine.
+func (a *AsyncObserver) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu         sync.Mutex
+	decisions  []DecisionEvent
+	cleanups   []CleanupEvent
+	failovers  []FailoverEvent
+	reconfigs  []ReconfigureEvent
+	onDecision func()
+}
+
+func (o *recordingObserver) OnDecision(e DecisionEvent) {
+	if o.onDecision != nil {
+		o.onDecision()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.decisions = append(o.decisions, e)
+}
+
+func (o *recordingObserver) OnCleanup(e CleanupEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cleanups = append(o.cleanups, e)
+}
+
+func (o *recordingObserver) OnFailover(e FailoverEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failovers = append(o.failovers, e)
+}
+
+func (o *recordingObserver) OnReconfigure(e ReconfigureEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.reconfigs = append(o.reconfigs, e)
+}
+
+func TestManagerNotifiesObservers(t *testing.T) {
+	first, second := &recordingObserver{}, &recordingObserver{}
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour,
+		WithPolicyName("login"), WithObserver(first), WithObserver(second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	m.Allow("a")
+	m.Allow("a")
+	m.Cleanup()
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Second}
+	if err := m.SetOverride("a", cfg, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.ClearOverride("a")
+
+	for _, o := range []*recordingObserver{first, second} {
+		if len(o.decisions) != 2 {
+			t.Fatalf("expected 2 decision events, got %d", len(o.decisions))
+		}
+		if d := o.decisions[1]; d.Policy != "login" || d.Key != "a" || d.Decision.Allowed || d.Latency <= 0 {
+			t.Fatalf("unexpected decision event %+v", d)
+		}
+		if len(o.cleanups) != 1 || o.cleanups[0].Err != nil || o.cleanups[0].Policy != "login" {
+			t.Fatalf("unexpected cleanup events %+v", o.cleanups)
+		}
+		if len(o.reconfigs) != 2 || o.reconfigs[0].Config != cfg || o.reconfigs[0].Removed || !o.reconfigs[1].Removed {
+			t.Fatalf("unexpected reconfigure events %+v", o.reconfigs)
+		}
+	}
+}
+
+func TestManagerReportsStoreFailover(t *testing.T) {
+	peers := startTestPeers(t, 2)
+	obs := &recordingObserver{}
+	m, err := NewManagerWithStore(peers[0].store, 1, 1, time.Hour, time.Minute, time.Hour, WithObserver(obs))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	key := ownedBy(t, peers[0].store, peers[1].server.URL)
+	peers[1].server.Close()
+
+	if !m.Allow(key) {
+		t.Fatal("expected local fallback to allow")
+	}
+	if len(obs.failovers) != 1 || obs.failovers[0].Policy != defaultPolicyName || obs.failovers[0].Key != key {
+		t.Fatalf("unexpected failover events %+v", obs.failovers)
+	}
+
+	m.Stop()
+	if n := len(peers[0].store.failoverHandlers); n != 0 {
+		t.Fatalf("expected Stop to unregister the failover handler, %d left", n)
+	}
+}
+
+func TestAsyncObserverDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	slow := &recordingObserver{onDecision: func() { <-release }}
+	async := NewAsyncObserver(slow, 2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 10 {
+			async.OnDecision(DecisionEvent{Key: "k"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected async observer not to block the caller")
+	}
+
+	close(release)
+	async.Close()
+
+	delivered := int64(len(slow.decisions))
+	if delivered+async.Dropped() != 10 || async.Dropped() == 0 {
+		t.Fatalf("expected drops to account for undelivered events, delivered %d, dropped %d", delivered, async.Dropped())
+	}
+
+	async.OnCleanup(CleanupEvent{Err: errors.New("late")})
+	if len(slow.cleanups) != 0 || async.Dropped() != 10-delivered+1 {
+		t.Fatal("expected events after Close to be dropped")
+	}
+}
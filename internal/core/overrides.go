@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManagerConfig is the configuration a Manager was created with.
+type ManagerConfig struct {
+	Policy          string        `json:"policy"`
+	Capacity        int64         `json:"capacity"`
+	RefillRate      int64         `json:"refill_rate"`
+	Interval        time.Duration `json:"interval"`
+	BucketTTL       time.Duration `json:"bucket_ttl"`
+	CleanupInterval time.Duration `json:"cleanup_interval"`
+}
+
+// Override replaces the bucket config of a single key until ExpiresAt.
+type Override struct {
+	Key       string       `json:"key"`
+	Config    BucketConfig `json:"config"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+func (m *Manager) Config() ManagerConfig {
+	return ManagerConfig{
+		Policy:          m.policy,
+		Capacity:        m.capacity,
+		RefillRate:      m.refillRate,
+		Interval:        m.interval,
+		BucketTTL:       m.bucketTTL,
+		CleanupInterval: m.cleanupInterval,
+	}
+}
+
+// SetOverride applies cfg to key instead of the Manager's config for ttl.
+func (m *Manager) SetOverride(key string, cfg BucketConfig, ttl time.Duration) error {
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+	if err := validateTokenBucketConfig(cfg.Capacity, cfg.RefillRate, cfg.Interval); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return errors.New("override TTL must be greater than 0")
+	}
+
+	o := Override{Key: key, Config: cfg, ExpiresAt: time.Now().Add(ttl)}
+
+	m.overridesMu.Lock()
+	next := maps.Clone(m.currentOverrides())
+	if next == nil {
+		next = make(map[string]Override)
+	}
+	next[key] = o
+	m.overrides.Store(&next)
+	m.overridesMu.Unlock()
+
+	m.notifyReconfigure(ReconfigureEvent{Key: key, Config: cfg, ExpiresAt: o.ExpiresAt})
+	return nil
+}
+
+// ClearOverride removes the override for key, if any.
+func (m *Manager) ClearOverride(key string) {
+	m.overridesMu.Lock()
+	current := m.currentOverrides()
+	if _, ok := current[key]; !ok {
+		m.overridesMu.Unlock()
+		return
+	}
+	next := maps.Clone(current)
+	delete(next, key)
+	m.overrides.Store(&next)
+	m.overridesMu.Unlock()
+
+	m.notifyReconfigure(ReconfigureEvent{Key: key, Config: m.bucketConfig(), Removed: true})
+}
+
+// Overrides returns the active overrides sorted by key.
+func (m *Manager) Overrides() []Override {
+	now := time.Now()
+	var out []Override
+	for _, o := range m.currentOverrides() {
+		if now.Before(o.ExpiresAt) {
+			out = append(out, o)
+		}
+	}
+	slices.SortFunc(out, func(a, b Override) int { return strings.Compare(a.Key, b.Key) })
+	return out
+}
+
+func (m *Manager) currentOverrides() map[string]Override {
+	if p := m.overrides.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// configFor returns the bucket config that applies to key right now.
+func (m *Manager) configFor(key string) BucketConfig {
+	if overrides := m.currentOverrides(); len(overrides) > 0 {
+		if o, ok := overrides[key]; ok && time.Now().Before(o.ExpiresAt) {
+			return o.Config
+		}
+	}
+	return m.bucketConfig()
+}
+
+// pruneOverrides drops expired overrides; it runs with cleanup.
+func (m *Manager) pruneOverrides(now time.Time) {
+	var expired []string
+
+	m.overridesMu.Lock()
+	current := m.currentOverrides()
+	next := maps.Clone(current)
+	maps.DeleteFunc(next, func(key string, o Override) bool {
+		if now.Before(o.ExpiresAt) {
+			return false
+		}
+		expired = append(expired, key)
+		return true
+	})
+	if len(expired) > 0 {
+		m.overrides.Store(&next)
+	}
+	m.overridesMu.Unlock()
+
+	for _, key := range expired {
+		m.notifyReconfigure(ReconfigureEvent{Key: key, Config: m.bucketConfig(), Removed: true})
+	}
+}
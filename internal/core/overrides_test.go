@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManagerOverrideChangesLimitForKey(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if err := m.SetOverride("vip", BucketConfig{Capacity: 3, RefillRate: 1, Interval: time.Hour}, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range 3 {
+		if !m.Allow("vip") {
+			t.Fatalf("expected overridden request %d to pass", i+1)
+		}
+	}
+	if m.Allow("vip") {
+		t.Fatal("expected overridden limit to be enforced")
+	}
+	if !m.Allow("other") || m.Allow("other") {
+		t.Fatal("expected other keys to keep the default limit")
+	}
+
+	if got := m.Overrides(); len(got) != 1 || got[0].Key != "vip" {
+		t.Fatalf("expected one override for vip, got %+v", got)
+	}
+	m.ClearOverride("vip")
+	if got := m.Overrides(); len(got) != 0 {
+		t.Fatalf("expected no overrides after clear, got %+v", got)
+	}
+	if d, _ := m.AllowDecision("vip"); d.Limit != 1 {
+		t.Fatalf("expected default limit after clear, got %+v", d)
+	}
+}
+
+func TestManagerOverrideExpires(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if err := m.SetOverride("vip", BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}, 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if d, _ := m.AllowDecision("vip"); d.Limit != 1 {
+		t.Fatalf("expected expired override to be ignored, got %+v", d)
+	}
+	m.Cleanup()
+	if p := m.overrides.Load(); p != nil && len(*p) != 0 {
+		t.Fatalf("expected cleanup to prune expired overrides, got %d", len(*p))
+	}
+}
+
+func TestManagerSetOverrideValidates(t *testing.T) {
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	valid := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Second}
+	if err := m.SetOverride("", valid, time.Minute); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+	if err := m.SetOverride("k", BucketConfig{}, time.Minute); err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+	if err := m.SetOverride("k", valid, 0); err == nil {
+		t.Fatal("expected error for non-positive TTL")
+	}
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// maxBucketSpan caps capacity*perToken so time arithmetic relative to the
+// store epoch cannot overflow for absurdly large capacities.
+const maxBucketSpan = math.MaxInt64 / 4
+
+// packedBucket is the compact, lock-free token bucket used by MemoryStore.
+//
+// Instead of storing tokens and the last refill time separately, it keeps a
+// single "empty at" timestamp z (nanoseconds since the store epoch): at time
+// now the bucket holds min(capacity, (now-z)/perToken) tokens. Taking a token
+// moves z forward by perToken, so every update is a single CAS on one word.
+type packedBucket struct {
+	emptyAt  atomic.Int64
+	lastSeen atomic.Int64
+
+	capacity int64
+	perToken int64
+}
+
+func (b *packedBucket) init(cfg BucketConfig, now int64) error {
+	if err := validateTokenBucketConfig(cfg.Capacity, cfg.RefillRate, cfg.Interval); err != nil {
+		return err
+	}
+	b.capacity = cfg.Capacity
+	b.perToken = int64(retryAfterForConfig(cfg, false))
+	b.emptyAt.Store(now - b.span())
+	b.lastSeen.Store(now)
+	return nil
+}
+
+// matches reports whether the bucket was created from an equivalent config.
+func (b *packedBucket) matches(cfg BucketConfig) bool {
+	return b.capacity == cfg.Capacity && b.perToken == int64(retryAfterForConfig(cfg, false))
+}
+
+// span is the time it takes an empty bucket to refill completely.
+func (b *packedBucket) span() int64 {
+	if b.capacity > maxBucketSpan/b.perToken {
+		return maxBucketSpan
+	}
+	return b.capacity * b.perToken
+}
+
+// tokensAt returns the number of whole tokens available at now for the given
+// empty-at timestamp. It is negative or zero while the bucket is empty,
+// including when the clock has stepped backwards.
+func (b *packedBucket) tokensAt(emptyAt, now int64) int64 {
+	if now-emptyAt >= b.span() {
+		return b.capacity
+	}
+	return (now - emptyAt) / b.perToken
+}
+
+func (b *packedBucket) allow(now int64) Decision {
+	b.lastSeen.Store(now)
+
+	for {
+		z := b.emptyAt.Load()
+		tokens := b.tokensAt(z, now)
+		if tokens <= 0 {
+			retryAfter := time.Duration(z + b.perToken - now)
+			if retryAfter < 0 {
+				retryAfter = 0
+			}
+			return Decision{Limit: b.capacity, RetryAfter: retryAfter}
+		}
+
+		next := z
+		if full := now - b.span(); next < full {
+			next = full
+		}
+		next += b.perToken
+		if b.emptyAt.CompareAndSwap(z, next) {
+			return Decision{
+				Allowed:   true,
+				Limit:     b.capacity,
+				Remaining: tokens - 1,
+			}
+		}
+	}
+}
+
+// peek returns what allow would decide at now, without taking a token.
+func (b *packedBucket) peek(now int64) Decision {
+	z := b.emptyAt.Load()
+	tokens := b.tokensAt(z, now)
+	if tokens <= 0 {
+		return Decision{
+			Limit:      b.capacity,
+			RetryAfter: max(time.Duration(z+b.perToken-now), 0),
+		}
+	}
+	return Decision{Allowed: true, Limit: b.capacity, Remaining: tokens}
+}
+
+// fill sets the bucket to hold tokens tokens at now, clamped to
+// [0, capacity].
+func (b *packedBucket) fill(tokens int64, now int64) {
+	tokens = min(max(tokens, 0), b.capacity)
+	filled := b.span()
+	if tokens < b.capacity {
+		filled = tokens * b.perToken
+	}
+	b.emptyAt.Store(now - filled)
+}
+
+// consume removes n tokens that were spent elsewhere, without granting
+// anything locally. The bucket may go into debt, which delays local grants
+// until it is repaid by refill; the debt is capped at one full bucket.
+func (b *packedBucket) consume(n int64, now int64) {
+	if n <= 0 {
+		return
+	}
+	b.lastSeen.Store(now)
+	cost := b.span()
+	if n <= cost/b.perToken {
+		cost = n * b.perToken
+	}
+
+	for {
+		z := b.emptyAt.Load()
+		next := max(z, now-b.span()) + cost
+		if limit := now + b.span(); next > limit {
+			next = max(z, limit)
+		}
+		if b.emptyAt.CompareAndSwap(z, next) {
+			return
+		}
+	}
+}
+
+// state returns the bucket contents at now as whole tokens plus the time the
+// last of them was added, which is the form snapshots store.
+func (b *packedBucket) state(now int64) (tokens int64, lastRefill int64) {
+	z := b.emptyAt.Load()
+	tokens = b.tokensAt(z, now)
+	switch {
+	case tokens >= b.capacity:
+		return b.capacity, now
+	case tokens < 0:
+		return 0, z
+	default:
+		return tokens, z + tokens*b.perToken
+	}
+}
+
+// merge combines another copy of the same bucket into b, keeping the fewer
+// tokens of the two and the later last-seen time.
+func (b *packedBucket) merge(o *packedBucket) {
+	storeMax(&b.emptyAt, o.emptyAt.Load())
+	storeMax(&b.lastSeen, o.lastSeen.Load())
+}
+
+// storeMax raises v to n unless it already holds more.
+func storeMax(v *atomic.Int64, n int64) {
+	for {
+		cur := v.Load()
+		if cur >= n || v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// restore sets the bucket from a state previously returned by state. Tokens
+// accrued since lastRefill are picked up by the next allow.
+func (b *packedBucket) restore(capacity int64, perToken int64, tokens int64, lastRefill int64, lastSeen int64) {
+	b.capacity = capacity
+	b.perToken = perToken
+	if tokens > capacity {
+		tokens = capacity
+	}
+	filled := b.span()
+	if tokens < capacity && tokens <= filled/perToken {
+		filled = tokens * perToken
+	}
+	b.emptyAt.Store(lastRefill - filled)
+	b.lastSeen.Store(lastSeen)
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPackedBucketMatchesTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	cfg := BucketConfig{Capacity: 5, RefillRate: 2, Interval: time.Second}
+
+	tb, err := newTokenBucket(cfg.Capacity, cfg.RefillRate, cfg.Interval, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pb packedBucket
+	if err := pb.init(cfg, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	steps := []time.Duration{0, 0, 0, 0, 0, 0, 100 * time.Millisecond, 400 * time.Millisecond, 0, 0, 501 * time.Millisecond, 3 * time.Second, 0, 0, 0, 0, 0}
+	for i, step := range steps {
+		now = now.Add(step)
+		want := tb.allowDecision()
+		got := pb.allow(int64(now.Sub(time.Unix(0, 0))))
+		if got.Allowed != want.Allowed || got.Remaining != want.Remaining || got.Limit != want.Limit {
+			t.Fatalf("step %d: packed bucket %+v diverged from token bucket %+v", i, got, want)
+		}
+	}
+}
+
+func TestPackedBucketConcurrent(t *testing.T) {
+	var pb packedBucket
+	if err := pb.init(BucketConfig{Capacity: 100, RefillRate: 1, Interval: time.Hour}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for range 500 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if pb.allow(0).Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 100 {
+		t.Fatalf("expected 100 allowed, got %d", allowed.Load())
+	}
+}
+
+func TestPackedBucketHugeCapacity(t *testing.T) {
+	var pb packedBucket
+	if err := pb.init(BucketConfig{Capacity: 1 << 62, RefillRate: 1, Interval: time.Hour}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := pb.allow(0); !d.Allowed || d.Remaining <= 0 {
+		t.Fatalf("expected huge bucket to allow, got %+v", d)
+	}
+}
+
+var benchmarkBucketConfig = BucketConfig{Capacity: 1 << 30, RefillRate: 1000, Interval: time.Second}
+
+func BenchmarkBucketAllow(b *testing.B) {
+	for _, goroutines := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("token-bucket/goroutines=%d", goroutines), func(b *testing.B) {
+			tb, _ := NewTokenBucket(benchmarkBucketConfig.Capacity, benchmarkBucketConfig.RefillRate, benchmarkBucketConfig.Interval)
+			runConcurrently(b, goroutines, func() { tb.allowDecision() })
+		})
+		b.Run(fmt.Sprintf("packed/goroutines=%d", goroutines), func(b *testing.B) {
+			var pb packedBucket
+			_ = pb.init(benchmarkBucketConfig, 0)
+			epoch := time.Now()
+			runConcurrently(b, goroutines, func() { pb.allow(int64(time.Since(epoch))) })
+		})
+	}
+}
+
+var (
+	tokenBucketSink  *TokenBucket
+	packedBucketSink *packedBucket
+)
+
+func BenchmarkBucketAlloc(b *testing.B) {
+	b.Run("token-bucket", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			tokenBucketSink, _ = NewTokenBucket(benchmarkBucketConfig.Capacity, benchmarkBucketConfig.RefillRate, benchmarkBucketConfig.Interval)
+		}
+	})
+	b.Run("packed", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			packedBucketSink = new(packedBucket)
+			_ = packedBucketSink.init(benchmarkBucketConfig, 0)
+		}
+	})
+}
+
+func runConcurrently(b *testing.B, goroutines int, fn func()) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	perWorker := b.N/goroutines + 1
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				fn()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,519 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPeerReplicas = 64
+	defaultPeerBasePath = "/_ratelimiter/peer"
+	defaultPeerTimeout  = 500 * time.Millisecond
+
+	defaultPeerRetryInterval = time.Second
+	maxPeerRetryInterval     = 30 * time.Second
+)
+
+var errPeerDown = errors.New("peer is down after a failed request")
+
+type PeerStoreOptions struct {
+	// Self is this instance's base URL as the other peers reach it, e.g.
+	// "http://10.0.0.1:8080". Required.
+	Self string
+	// Peers is the initial list of peer base URLs. Self is added if missing.
+	Peers []string
+	// Replicas is the number of points each peer gets on the hash ring.
+	// Defaults to 64.
+	Replicas int
+	// BasePath is where Handler is mounted on every peer. Defaults to
+	// "/_ratelimiter/peer".
+	BasePath string
+	// Client is used to reach other peers. Defaults to a client with a 500ms
+	// timeout.
+	Client *http.Client
+	// RetryInterval is how long a peer that failed a request is treated as
+	// down, with its keys decided locally, before one request probes it
+	// again. It doubles after each failed probe, up to 30s. Defaults to 1s.
+	RetryInterval time.Duration
+	// Memory configures the local stores holding owned and fallback buckets.
+	Memory MemoryStoreOptions
+	// Logger, if set, receives failed handoffs. Failovers are reported
+	// through OnFailover.
+	Logger *slog.Logger
+	// Authorize decides whether a request to Handler comes from a peer. A
+	// nil Authorize rejects every request, since the peer endpoints can
+	// drain any key or overwrite its bucket.
+	Authorize func(*http.Request) bool
+	// Header is added to every request sent to other peers, e.g. a shared
+	// Authorization token checked by Authorize.
+	Header http.Header
+}
+
+// PeerStore spreads key ownership across application instances with a
+// consistent-hash ring. Allow calls for keys owned by another peer are
+// forwarded to it over HTTP; if the owner cannot be reached the decision is
+// made locally, so limits degrade to per-instance instead of failing. An owner
+// that fails a request is skipped until a probe after RetryInterval succeeds,
+// so requests do not each wait for the client timeout.
+//
+// Every peer must serve Handler under BasePath. The peer endpoints must not
+// be reachable from outside the cluster.
+type PeerStore struct {
+	self      string
+	basePath  string
+	replicas  int
+	client    *http.Client
+	authorize func(*http.Request) bool
+	header    http.Header
+
+	mu   sync.RWMutex
+	ring *hashRing
+
+	// owned holds buckets for keys this peer owns. fallback holds buckets
+	// for keys whose owner was unreachable; they are never handed off.
+	owned    *MemoryStore
+	fallback *MemoryStore
+
+	handoffWG sync.WaitGroup
+	logger    *slog.Logger
+
+	// down holds the peers that failed a request; downPeers mirrors its
+	// size so Allow can skip downMu while every peer is up.
+	retryInterval time.Duration
+	downMu        sync.Mutex
+	down          map[string]*peerBackoff
+	downPeers     atomic.Int32
+
+	failoverMu       sync.RWMutex
+	failoverHandlers []*func(FailoverEvent)
+}
+
+// peerBackoff is the state of a peer that is down.
+type peerBackoff struct {
+	retryAt time.Time
+	wait    time.Duration
+	probing bool
+}
+
+type peerAllowRequest struct {
+	Key        string        `json:"key"`
+	Capacity   int64         `json:"capacity"`
+	RefillRate int64         `json:"refill_rate"`
+	Interval   time.Duration `json:"interval_ns"`
+}
+
+type peerAllowResponse struct {
+	Allowed    bool          `json:"allowed"`
+	Remaining  int64         `json:"remaining"`
+	Limit      int64         `json:"limit"`
+	RetryAfter time.Duration `json:"retry_after_ns"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type peerHandoffRequest struct {
+	Buckets []bucketSnapshot `json:"buckets"`
+}
+
+func NewPeerStore(opts PeerStoreOptions) (*PeerStore, error) {
+	if opts.Self == "" {
+		return nil, errors.New("self peer URL cannot be empty")
+	}
+	replicas := opts.Replicas
+	if replicas <= 0 {
+		replicas = defaultPeerReplicas
+	}
+	basePath := strings.TrimSuffix(opts.BasePath, "/")
+	if basePath == "" {
+		basePath = defaultPeerBasePath
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultPeerTimeout}
+	}
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultPeerRetryInterval
+	}
+
+	s := &PeerStore{
+		self:      normalizePeer(opts.Self),
+		basePath:  basePath,
+		replicas:  replicas,
+		client:    client,
+		authorize: opts.Authorize,
+		header:    opts.Header,
+		owned:     NewMemoryStoreWithOptions(opts.Memory),
+		fallback:  NewMemoryStoreWithOptions(opts.Memory),
+		logger:    opts.Logger,
+
+		retryInterval: retryInterval,
+		down:          make(map[string]*peerBackoff),
+	}
+	s.ring = newHashRing(s.withSelf(opts.Peers), replicas)
+	return s, nil
+}
+
+func normalizePeer(peer string) string {
+	return strings.TrimSuffix(peer, "/")
+}
+
+func (s *PeerStore) withSelf(peers []string) []string {
+	out := make([]string, 0, len(peers)+1)
+	for _, p := range peers {
+		out = append(out, normalizePeer(p))
+	}
+	if !slices.Contains(out, s.self) {
+		out = append(out, s.self)
+	}
+	return out
+}
+
+// Owner returns the peer currently responsible for key.
+func (s *PeerStore) Owner(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.owner(key)
+}
+
+// SetPeers replaces the peer list. Buckets this peer owned that now belong to
+// another peer are handed off to their new owner in the background, so
+// clients keep their remaining tokens across rebalancing.
+func (s *PeerStore) SetPeers(peers []string) {
+	ring := newHashRing(s.withSelf(peers), s.replicas)
+
+	s.mu.Lock()
+	s.ring = ring
+	s.mu.Unlock()
+
+	s.downMu.Lock()
+	for peer := range s.down {
+		if !slices.Contains(ring.peers, peer) {
+			delete(s.down, peer)
+		}
+	}
+	s.downPeers.Store(int32(len(s.down)))
+	s.downMu.Unlock()
+
+	s.handoffWG.Add(1)
+	go func() {
+		defer s.handoffWG.Done()
+		s.handoff(ring)
+	}()
+}
+
+func (s *PeerStore) handoff(ring *hashRing) {
+	moving := make(map[string][]bucketSnapshot)
+	s.owned.forEachBucket(s.owned.nowNanos(), func(b bucketSnapshot) {
+		if owner := ring.owner(b.Key); owner != s.self {
+			moving[owner] = append(moving[owner], b)
+		}
+	})
+
+	for owner, buckets := range moving {
+		// Buckets that cannot be delivered stay here and expire through
+		// cleanup; the new owner starts those keys fresh.
+		if err := s.post(owner, "/handoff", peerHandoffRequest{Buckets: buckets}, nil); err != nil {
+			if s.logger != nil {
+				s.logger.Warn("peer store handoff failed",
+					slog.String("peer", owner),
+					slog.Int("buckets", len(buckets)),
+					slog.Any("error", err),
+				)
+			}
+			continue
+		}
+		for _, b := range buckets {
+			s.owned.deleteKey(b.Key)
+		}
+	}
+}
+
+func (s *PeerStore) Allow(key string, cfg BucketConfig) (Decision, error) {
+	owner := s.Owner(key)
+	if owner == s.self {
+		return s.owned.Allow(key, cfg)
+	}
+
+	if !s.available(owner) {
+		s.reportFailover(FailoverEvent{Key: key, Peer: owner, Err: errPeerDown, Time: time.Now()})
+		return s.fallback.Allow(key, cfg)
+	}
+
+	var resp peerAllowResponse
+	err := s.post(owner, "/allow", peerAllowRequest{
+		Key:        key,
+		Capacity:   cfg.Capacity,
+		RefillRate: cfg.RefillRate,
+		Interval:   cfg.Interval,
+	}, &resp)
+	if err != nil {
+		s.markDown(owner)
+		s.reportFailover(FailoverEvent{Key: key, Peer: owner, Err: err, Time: time.Now()})
+		return s.fallback.Allow(key, cfg)
+	}
+	s.markUp(owner)
+	if resp.Error != "" {
+		return Decision{}, errors.New(resp.Error)
+	}
+	return Decision{
+		Allowed:    resp.Allowed,
+		Remaining:  resp.Remaining,
+		Limit:      resp.Limit,
+		RetryAfter: resp.RetryAfter,
+	}, nil
+}
+
+// available reports whether a request may be sent to peer: it is up, or it
+// is due for a probe and no other request is probing it.
+func (s *PeerStore) available(peer string) bool {
+	if s.downPeers.Load() == 0 {
+		return true
+	}
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+
+	b := s.down[peer]
+	if b == nil {
+		return true
+	}
+	if b.probing || time.Now().Before(b.retryAt) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// markDown records a failed request to peer. A failed probe doubles the
+// wait before the next one; failures of requests sent before the peer went
+// down leave it alone.
+func (s *PeerStore) markDown(peer string) {
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+
+	b := s.down[peer]
+	switch {
+	case b == nil:
+		b = &peerBackoff{wait: s.retryInterval}
+		s.down[peer] = b
+		s.downPeers.Store(int32(len(s.down)))
+	case b.probing:
+		b.wait = min(2*b.wait, max(maxPeerRetryInterval, s.retryInterval))
+		b.probing = false
+	default:
+		return
+	}
+	b.retryAt = time.Now().Add(b.wait)
+}
+
+// markUp clears a successful peer's down state, if any.
+func (s *PeerStore) markUp(peer string) {
+	if s.downPeers.Load() == 0 {
+		return
+	}
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+	delete(s.down, peer)
+	s.downPeers.Store(int32(len(s.down)))
+}
+
+// OnFailover registers fn to be called whenever a key is served locally
+// because its owner was unreachable. Calling the returned func unregisters
+// fn.
+func (s *PeerStore) OnFailover(fn func(FailoverEvent)) func() {
+	handler := &fn
+	s.failoverMu.Lock()
+	defer s.failoverMu.Unlock()
+	s.failoverHandlers = append(s.failoverHandlers, handler)
+	return func() {
+		s.failoverMu.Lock()
+		defer s.failoverMu.Unlock()
+		s.failoverHandlers = slices.DeleteFunc(s.failoverHandlers, func(h *func(FailoverEvent)) bool {
+			return h == handler
+		})
+	}
+}
+
+func (s *PeerStore) reportFailover(e FailoverEvent) {
+	s.failoverMu.RLock()
+	defer s.failoverMu.RUnlock()
+	for _, fn := range s.failoverHandlers {
+		(*fn)(e)
+	}
+}
+
+func (s *PeerStore) post(peer string, path string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := postPeer(s.client, peer+s.basePath+path, s.header, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned %s", peer, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Handler serves requests forwarded by other peers. Mount it under BasePath.
+// Forwarded calls are always answered locally, even if this peer's view of
+// the ring differs, so requests never bounce between peers.
+func (s *PeerStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+s.basePath+"/allow", s.serveAllow)
+	mux.HandleFunc("POST "+s.basePath+"/handoff", s.serveHandoff)
+	return authorizePeer(s.authorize, mux)
+}
+
+// authorizePeer rejects requests authorize does not accept; a nil
+// authorize rejects all of them.
+func authorizePeer(authorize func(*http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// postPeer sends a JSON payload to another peer with header added.
+func postPeer(client *http.Client, url string, header http.Header, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return client.Do(req)
+}
+
+func (s *PeerStore) serveAllow(w http.ResponseWriter, r *http.Request) {
+	var req peerAllowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var resp peerAllowResponse
+	decision, err := s.owned.Allow(req.Key, BucketConfig{
+		Capacity:   req.Capacity,
+		RefillRate: req.RefillRate,
+		Interval:   req.Interval,
+	})
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp = peerAllowResponse{
+			Allowed:    decision.Allowed,
+			Remaining:  decision.Remaining,
+			Limit:      decision.Limit,
+			RetryAfter: decision.RetryAfter,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *PeerStore) serveHandoff(w http.ResponseWriter, r *http.Request) {
+	var req peerHandoffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, b := range req.Buckets {
+		if err := b.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Requests for these keys may already have reached this peer since the
+	// ring changed, so the handed-off state is merged rather than restored.
+	for _, b := range req.Buckets {
+		s.owned.mergeEntry(b)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *PeerStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	return errors.Join(
+		s.owned.DeleteInactiveBuckets(cutoff),
+		s.fallback.DeleteInactiveBuckets(cutoff),
+	)
+}
+
+// Close waits for in-flight handoffs to finish.
+func (s *PeerStore) Close() error {
+	s.handoffWG.Wait()
+	return nil
+}
+
+// hashRing is an immutable consistent-hash ring with virtual nodes.
+type hashRing struct {
+	peers  []string
+	points []uint64
+	owners map[uint64]string
+}
+
+func newHashRing(peers []string, replicas int) *hashRing {
+	r := &hashRing{peers: peers, owners: make(map[uint64]string, len(peers)*replicas)}
+	for _, peer := range peers {
+		for i := range replicas {
+			h := ringHash(strconv.Itoa(i) + "#" + peer)
+			if _, taken := r.owners[h]; taken {
+				continue
+			}
+			r.owners[h] = peer
+			r.points = append(r.points, h)
+		}
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// ringHash must be identical on every peer, so it cannot use a seeded hash.
+// FNV alone barely moves the high bits when only the last bytes differ (as
+// with peers that differ only by port), so the result goes through the
+// murmur3 finalizer to spread it over the ring.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
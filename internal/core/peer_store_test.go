@@ -0,0 +1,299 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testPeer struct {
+	store  *PeerStore
+	server *httptest.Server
+}
+
+// startTestPeers starts n peers that all know about each other.
+func startTestPeers(t *testing.T, n int) []*testPeer {
+	t.Helper()
+	peers := make([]*testPeer, n)
+	urls := make([]string, n)
+	for i := range peers {
+		p := &testPeer{}
+		p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.store.Handler().ServeHTTP(w, r)
+		}))
+		t.Cleanup(p.server.Close)
+		peers[i] = p
+		urls[i] = p.server.URL
+	}
+	for i, p := range peers {
+		store, err := NewPeerStore(PeerStoreOptions{
+			Self:      urls[i],
+			Peers:     urls,
+			Authorize: authorizeTestPeer,
+			Header:    testPeerHeader,
+		})
+		if err != nil {
+			t.Fatalf("failed to create peer store: %v", err)
+		}
+		p.store = store
+	}
+	return peers
+}
+
+var testPeerHeader = http.Header{"Authorization": {"Bearer peer-secret"}}
+
+func authorizeTestPeer(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer peer-secret"
+}
+
+// ownedBy returns a key that s maps to owner.
+func ownedBy(t *testing.T, s *PeerStore, owner string) string {
+	t.Helper()
+	for i := range 1000 {
+		if k := fmt.Sprintf("user-%d", i); s.Owner(k) == owner {
+			return k
+		}
+	}
+	t.Fatalf("no key owned by %s", owner)
+	return ""
+}
+
+func TestPeerStoreRequiresSelf(t *testing.T) {
+	if _, err := NewPeerStore(PeerStoreOptions{}); err == nil {
+		t.Fatal("expected error for missing self URL")
+	}
+}
+
+func TestPeerStoreHandlerRequiresAuthorization(t *testing.T) {
+	peers := startTestPeers(t, 1)
+	for _, header := range []http.Header{nil, {"Authorization": {"Bearer wrong"}}} {
+		req, _ := http.NewRequest(http.MethodPost, peers[0].server.URL+defaultPeerBasePath+"/allow",
+			strings.NewReader(`{"key":"victim","capacity":1,"refill_rate":1,"interval_ns":3600000000000}`))
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected 403 without the peer token, got %d", resp.StatusCode)
+		}
+	}
+
+	store, err := NewPeerStore(PeerStoreOptions{Self: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	store.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, defaultPeerBasePath+"/allow", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected nil Authorize to deny, got %d", rec.Code)
+	}
+}
+
+func TestHashRingSpreadsKeys(t *testing.T) {
+	peers := []string{"http://127.0.0.1:40001", "http://127.0.0.1:40002", "http://127.0.0.1:40003"}
+	ring := newHashRing(peers, defaultPeerReplicas)
+
+	counts := make(map[string]int)
+	for i := range 3000 {
+		counts[ring.owner(fmt.Sprintf("user-%d", i))]++
+	}
+	for _, p := range peers {
+		if counts[p] < 500 {
+			t.Fatalf("expected keys to spread across peers, got %v", counts)
+		}
+	}
+
+	// Adding a peer only moves keys onto the new peer.
+	grown := newHashRing(append(peers, "http://127.0.0.1:40004"), defaultPeerReplicas)
+	for i := range 3000 {
+		key := fmt.Sprintf("user-%d", i)
+		if before, after := ring.owner(key), grown.owner(key); before != after && after != "http://127.0.0.1:40004" {
+			t.Fatalf("key %s moved from %s to %s", key, before, after)
+		}
+	}
+}
+
+func TestPeerStoreClusterWideLimit(t *testing.T) {
+	peers := startTestPeers(t, 3)
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+
+	for key := range 10 {
+		k := fmt.Sprintf("user-%d", key)
+		allowed := 0
+		for i := range 15 {
+			d, err := peers[i%len(peers)].store.Allow(k, cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.Allowed {
+				allowed++
+			}
+		}
+		if allowed != 5 {
+			t.Fatalf("key %s: expected 5 allowed across the cluster, got %d", k, allowed)
+		}
+	}
+}
+
+func TestPeerStoreFallsBackWhenOwnerUnreachable(t *testing.T) {
+	peers := startTestPeers(t, 2)
+	cfg := BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Hour}
+
+	key := ownedBy(t, peers[0].store, peers[1].server.URL)
+	peers[1].server.Close()
+
+	var failovers []FailoverEvent
+	unsubscribe := peers[0].store.OnFailover(func(e FailoverEvent) { failovers = append(failovers, e) })
+
+	for i := range 2 {
+		d, err := peers[0].store.Allow(key, cfg)
+		if err != nil || !d.Allowed {
+			t.Fatalf("request %d: expected local fallback to allow, got %+v, %v", i, d, err)
+		}
+	}
+	if d, _ := peers[0].store.Allow(key, cfg); d.Allowed {
+		t.Fatal("expected local fallback to enforce the limit")
+	}
+	if len(failovers) != 3 || failovers[0].Key != key || failovers[0].Peer != peers[1].server.URL || failovers[0].Err == nil {
+		t.Fatalf("expected a failover event per request, got %+v", failovers)
+	}
+
+	unsubscribe()
+	peers[0].store.Allow(key, cfg)
+	if len(failovers) != 3 {
+		t.Fatalf("expected no events after unsubscribing, got %d", len(failovers))
+	}
+}
+
+func TestPeerStoreHandsOffBucketsOnRebalance(t *testing.T) {
+	peers := startTestPeers(t, 2)
+	cfg := BucketConfig{Capacity: 3, RefillRate: 1, Interval: time.Hour}
+	a, b := peers[0], peers[1]
+
+	// Start with a single-peer view so a owns every key.
+	a.store.SetPeers(nil)
+	b.store.SetPeers(nil)
+	a.store.handoffWG.Wait()
+	b.store.handoffWG.Wait()
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+		_, _ = a.store.Allow(keys[i], cfg)
+		_, _ = a.store.Allow(keys[i], cfg)
+	}
+
+	urls := []string{a.server.URL, b.server.URL}
+	b.store.SetPeers(urls)
+	a.store.SetPeers(urls)
+	a.store.handoffWG.Wait()
+
+	moved := 0
+	for _, k := range keys {
+		if a.store.Owner(k) == b.server.URL {
+			moved++
+		}
+		if d, _ := a.store.Allow(k, cfg); !d.Allowed || d.Remaining != 0 {
+			t.Fatalf("key %s: expected state to survive rebalancing, got %+v", k, d)
+		}
+	}
+	if moved == 0 {
+		t.Fatal("expected some keys to move to the new peer")
+	}
+	if a.store.owned.Len() != len(keys)-moved {
+		t.Fatalf("expected handed-off buckets to be removed locally, have %d", a.store.owned.Len())
+	}
+}
+
+func TestPeerStoreSkipsDownPeerUntilProbeSucceeds(t *testing.T) {
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	var owner *PeerStore
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		owner.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	const self = "http://127.0.0.1:1"
+	urls := []string{self, server.URL}
+	s, err := NewPeerStore(PeerStoreOptions{Self: self, Peers: urls, RetryInterval: 100 * time.Millisecond, Header: testPeerHeader})
+	if err != nil {
+		t.Fatalf("failed to create peer store: %v", err)
+	}
+	owner, _ = NewPeerStore(PeerStoreOptions{Self: server.URL, Peers: urls, Authorize: authorizeTestPeer})
+	cfg := BucketConfig{Capacity: 10, RefillRate: 1, Interval: time.Hour}
+	key := ownedBy(t, s, server.URL)
+
+	for range 5 {
+		if d, err := s.Allow(key, cfg); err != nil || !d.Allowed {
+			t.Fatalf("expected local fallback to allow, got %+v, %v", d, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected requests to skip the down peer after one failure, got %d", n)
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	_, _ = s.Allow(key, cfg)
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected one probe after the retry interval, got %d requests", n)
+	}
+
+	// The failed probe doubles the wait.
+	healthy.Store(true)
+	time.Sleep(120 * time.Millisecond)
+	_, _ = s.Allow(key, cfg)
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected the wait to double after a failed probe, got %d requests", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	for range 3 {
+		_, _ = s.Allow(key, cfg)
+	}
+	if n := requests.Load(); n != 5 {
+		t.Fatalf("expected requests to be forwarded once a probe succeeds, got %d", n)
+	}
+	if d, _ := owner.owned.Peek(key, cfg); d.Remaining != 7 {
+		t.Fatalf("expected the owner to decide after recovery, got %+v", d)
+	}
+}
+
+func TestPeerStoreHandoffKeepsNewerState(t *testing.T) {
+	peers := startTestPeers(t, 2)
+	cfg := BucketConfig{Capacity: 5, RefillRate: 1, Interval: time.Hour}
+	a, b := peers[0], peers[1]
+	key := ownedBy(t, a.store, b.server.URL)
+
+	// a still holds a stale bucket with one token spent, while b has
+	// already served three requests for the key since the ring changed.
+	_, _ = a.store.owned.Allow(key, cfg)
+	for range 3 {
+		_, _ = b.store.Allow(key, cfg)
+	}
+	a.store.handoff(a.store.ring)
+
+	if d, _ := b.store.owned.Peek(key, cfg); d.Remaining != 2 {
+		t.Fatalf("expected the handoff to keep the fewer tokens, got %+v", d)
+	}
+
+	// A handoff with fewer tokens wins.
+	for range 4 {
+		_, _ = a.store.owned.Allow(key, cfg)
+	}
+	a.store.handoff(a.store.ring)
+	if d, _ := b.store.owned.Peek(key, cfg); d.Remaining != 1 {
+		t.Fatalf("expected the handed-off bucket with fewer tokens to win, got %+v", d)
+	}
+}
@@ -0,0 +1,48 @@
+-- record_denial counts a denial in the ban hash key and bans the key once
+-- more than threshold denials fall within window_ms. It returns {level,
+-- banned_until_ms}.
+local function record_denial(key, now_ms, threshold, window_ms, duration_ms, multiplier, max_duration_ms, decay_ms)
+  local state = redis.call("HMGET", key, "denials", "window_start_ms", "level", "banned_until_ms")
+  local denials = tonumber(state[1]) or 0
+  local window_start_ms = tonumber(state[2]) or now_ms
+  local level = tonumber(state[3]) or 0
+  local banned_until_ms = tonumber(state[4]) or 0
+
+  if banned_until_ms > now_ms then
+    return {level, banned_until_ms}
+  end
+  if level > 0 and now_ms - banned_until_ms >= decay_ms then
+    level = 0
+  end
+  if now_ms - window_start_ms >= window_ms then
+    denials = 0
+    window_start_ms = now_ms
+  end
+
+  denials = denials + 1
+  if denials > threshold then
+    level = level + 1
+    local ban_ms = duration_ms * (multiplier ^ (level - 1))
+    if ban_ms > max_duration_ms then
+      ban_ms = max_duration_ms
+    end
+    banned_until_ms = now_ms + math.floor(ban_ms)
+    denials = 0
+    window_start_ms = now_ms
+  end
+
+  redis.call("HSET", key,
+    "denials", denials,
+    "window_start_ms", window_start_ms,
+    "level", level,
+    "banned_until_ms", banned_until_ms
+  )
+
+  local expires_ms = window_start_ms + window_ms
+  if level > 0 and banned_until_ms + decay_ms > expires_ms then
+    expires_ms = banned_until_ms + decay_ms
+  end
+  redis.call("PEXPIRE", key, expires_ms - now_ms)
+
+  return {level, banned_until_ms}
+end
@@ -0,0 +1,23 @@
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local refill_rate = tonumber(ARGV[2])
+local interval_ms = tonumber(ARGV[3])
+local now_ms = tonumber(ARGV[4])
+
+local tokens = tonumber(redis.call("HGET", key, "tokens"))
+local last_refill_ms = tonumber(redis.call("HGET", key, "last_refill_ms"))
+
+if not tokens or not last_refill_ms then
+  return {capacity, 0}
+end
+tokens = math.min(capacity, tokens)
+
+local elapsed = now_ms - last_refill_ms
+if elapsed > 0 then
+  local new_tokens = math.floor((elapsed * refill_rate) / interval_ms)
+  if new_tokens > 0 then
+    tokens = math.min(capacity, tokens + new_tokens)
+  end
+end
+
+return {tokens, 1}
@@ -0,0 +1,16 @@
+local key = KEYS[1]
+local tokens = tonumber(ARGV[1])
+local now_ms = tonumber(ARGV[2])
+local ttl_ms = tonumber(ARGV[3])
+
+redis.call("HSET", key,
+  "tokens", tokens,
+  "last_refill_ms", now_ms,
+  "last_seen_ms", now_ms
+)
+
+if ttl_ms > 0 then
+  redis.call("PEXPIRE", key, ttl_ms)
+end
+
+return 1
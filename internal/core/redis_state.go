@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+)
+
+//go:embed redis_peek_script.lua
+var redisPeekLua string
+
+//go:embed redis_set_script.lua
+var redisSetLua string
+
+const redisDeleteLua = `return redis.call("DEL", KEYS[1])`
+
+func (s *RedisStore) Peek(key string, cfg BucketConfig) (Decision, error) {
+	intervalMs, err := validateRedisRequest(key, cfg)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	result, err := s.client.Eval(context.Background(), redisPeekLua, []string{s.bucketKey(key)},
+		cfg.Capacity,
+		cfg.RefillRate,
+		intervalMs,
+		s.now().UnixMilli(),
+	)
+	if err != nil {
+		return Decision{}, err
+	}
+	values, ok := result.([]any)
+	if !ok || len(values) != 2 {
+		return Decision{}, fmt.Errorf("unexpected redis lua result: %T", result)
+	}
+	tokens, err := toInt64(values[0])
+	if err != nil {
+		return Decision{}, err
+	}
+
+	allowed := tokens > 0
+	return Decision{
+		Allowed:    allowed,
+		Remaining:  tokens,
+		Limit:      cfg.Capacity,
+		RetryAfter: retryAfterForConfig(cfg, allowed),
+	}, nil
+}
+
+// Reset deletes the key: a missing bucket starts full on the next Allow.
+func (s *RedisStore) Reset(key string) error {
+	return s.Delete(key)
+}
+
+func (s *RedisStore) Delete(key string) error {
+	if key == "" {
+		return errors.New("key cannot be empty")
+	}
+	_, err := s.client.Eval(context.Background(), redisDeleteLua, []string{s.bucketKey(key)})
+	return err
+}
+
+func (s *RedisStore) Set(key string, cfg BucketConfig, tokens int64) error {
+	if _, err := validateRedisRequest(key, cfg); err != nil {
+		return err
+	}
+	tokens = min(max(tokens, 0), cfg.Capacity)
+
+	_, err := s.client.Eval(context.Background(), redisSetLua, []string{s.bucketKey(key)},
+		tokens,
+		s.now().UnixMilli(),
+		s.ttl.Milliseconds(),
+	)
+	return err
+}
@@ -6,21 +6,38 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
 //go:embed token_bucken_redis_script.lua
-var tokenBucketRedisLua string
+var tokenBucketRedisBody string
+
+// tokenBucketRedisLua can record a denial, so it includes record_denial.
+var tokenBucketRedisLua = redisRecordDenialLua + tokenBucketRedisBody
 
 type RedisStoreOptions struct {
 	KeyPrefix string
 	KeyTTL    time.Duration
+	// Now overrides the clock whose timestamps are passed to the Lua script.
+	// Defaults to time.Now.
+	Now func() time.Time
+	// Tracer, if set, records a span for every Allow call.
+	Tracer Tracer
+	// AccessLists enables the AccessListStore methods. Decisions then also
+	// look the key up in the access lists, which costs a GET inside the
+	// token bucket script. Without it, setting a list fails with an error
+	// wrapping errors.ErrUnsupported.
+	AccessLists bool
 }
 
 type RedisStore struct {
 	client RedisEvalClient
 	prefix string
 	ttl    time.Duration
+	now    func() time.Time
+	tracer Tracer
+	lists  bool
 }
 
 type RedisEvalClient interface {
@@ -40,68 +57,165 @@ func NewRedisStore(client RedisEvalClient, opts RedisStoreOptions) (*RedisStore,
 	if ttl <= 0 {
 		ttl = 10 * time.Minute
 	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
 
 	return &RedisStore{
 		client: client,
 		prefix: prefix,
 		ttl:    ttl,
+		now:    now,
+		tracer: opts.Tracer,
+		lists:  opts.AccessLists,
 	}, nil
 }
 
 func (s *RedisStore) Allow(key string, cfg BucketConfig) (Decision, error) {
-	if key == "" {
-		return Decision{}, errors.New("key cannot be empty")
+	return s.AllowContext(context.Background(), key, cfg)
+}
+
+// AllowContext is Allow using ctx for the Redis call.
+func (s *RedisStore) AllowContext(ctx context.Context, key string, cfg BucketConfig) (Decision, error) {
+	decision, _, err := s.traceAllow(ctx, key, cfg, nil)
+	return decision, err
+}
+
+// traceAllow is allow, recorded in a span when the store has a Tracer.
+func (s *RedisStore) traceAllow(ctx context.Context, key string, cfg BucketConfig, g *allowGuard) (Decision, Ban, error) {
+	if s.tracer == nil {
+		return s.allow(ctx, key, cfg, g)
 	}
-	if cfg.Interval <= 0 {
-		return Decision{}, errors.New("interval must be greater than 0")
+
+	ctx, span := s.tracer.Start(ctx, "ratelimiter.redis.allow")
+	defer span.End()
+
+	start := time.Now()
+	decision, ban, err := s.allow(ctx, key, cfg, g)
+	span.SetAttributes(
+		Attribute{Key: "ratelimiter.key_hash", Value: keyHash(key)},
+		latencyAttribute("ratelimiter.store_latency_ms", time.Since(start)),
+	)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(decisionAttributes(decision)...)
 	}
-	if cfg.Capacity <= 0 || cfg.RefillRate <= 0 {
-		return Decision{}, errors.New("capacity and refill rate must be greater than 0")
+	return decision, ban, err
+}
+
+// allow runs the token bucket script for key. With a guard, the script
+// also looks key up in the access lists if g.lists is set and, with bans,
+// checks its ban and records its denial, so the whole decision takes one
+// EVAL. The keys it touches share key's hash tag.
+func (s *RedisStore) allow(ctx context.Context, key string, cfg BucketConfig, g *allowGuard) (Decision, Ban, error) {
+	intervalMs, err := validateRedisRequest(key, cfg)
+	if err != nil {
+		return Decision{}, Ban{}, err
 	}
 
-	nowMs := time.Now().UnixMilli()
-	intervalMs := cfg.Interval.Milliseconds()
+	nowMs := s.now().UnixMilli()
 	ttlMs := s.ttl.Milliseconds()
-	if intervalMs <= 0 {
-		return Decision{}, errors.New("interval must be at least 1ms")
-	}
 	if ttlMs <= 0 {
 		ttlMs = intervalMs
 	}
 
-	result, err := s.client.Eval(context.Background(), tokenBucketRedisLua, []string{s.prefixedKey(key)},
-		cfg.Capacity,
-		cfg.RefillRate,
-		intervalMs,
-		nowMs,
-		ttlMs,
-	)
+	keys := []string{s.bucketKey(key)}
+	args := []any{cfg.Capacity, cfg.RefillRate, intervalMs, nowMs, ttlMs}
+	if g != nil {
+		var p BanPolicy
+		if g.bans != nil {
+			p = *g.bans
+		}
+		keys = append(keys, s.listKey(key), s.banKey(key))
+		args = append(args,
+			redisFlag(g.lists),
+			redisFlag(g.bans != nil),
+			redisFlag(g.recordDenials),
+			p.Threshold,
+			p.Window.Milliseconds(),
+			p.Duration.Milliseconds(),
+			p.Multiplier,
+			p.MaxDuration.Milliseconds(),
+			p.Decay.Milliseconds(),
+		)
+	}
+	result, err := s.client.Eval(ctx, tokenBucketRedisLua, keys, args...)
 	if err != nil {
-		return Decision{}, err
+		return Decision{}, Ban{}, err
 	}
 
+	// The script returns {allowed, tokens}, {0, 0, list} for listed keys
+	// and {allowed, tokens, level, banned_until_ms, recorded} when the key
+	// is banned or its denial was recorded.
 	values, ok := result.([]any)
-	if !ok || len(values) != 2 {
-		return Decision{}, fmt.Errorf("unexpected redis lua result: %T", result)
+	if !ok || (len(values) != 2 && len(values) != 3 && len(values) != 5) {
+		return Decision{}, Ban{}, fmt.Errorf("unexpected redis lua result: %T", result)
+	}
+	if len(values) == 3 {
+		v, ok := values[2].(string)
+		if !ok {
+			return Decision{}, Ban{}, fmt.Errorf("unexpected redis lua result: %T", values[2])
+		}
+		list, err := parseAccessList(v)
+		if err != nil {
+			return Decision{}, Ban{}, err
+		}
+		return listedDecision(list), Ban{}, nil
 	}
 
 	allowed, err := toInt64(values[0])
 	if err != nil {
-		return Decision{}, err
+		return Decision{}, Ban{}, err
 	}
 	remaining, err := toInt64(values[1])
 	if err != nil {
-		return Decision{}, err
+		return Decision{}, Ban{}, err
 	}
-
-	return Decision{
+	decision := Decision{
 		Allowed:   allowed == 1,
 		Remaining: remaining,
 		Limit:     cfg.Capacity,
 		// For now this is an approximation for blocked responses.
 		// It can be made exact later by returning reset metadata from Lua.
 		RetryAfter: retryAfterForConfig(cfg, allowed == 1),
-	}, nil
+	}
+	if len(values) == 2 {
+		return decision, Ban{}, nil
+	}
+
+	ban, err := parseRedisBan(key, values[2:4], nowMs)
+	if err != nil || ban.Until.IsZero() {
+		return decision, Ban{}, err
+	}
+	recorded, err := toInt64(values[4])
+	if err != nil {
+		return Decision{}, Ban{}, err
+	}
+	if recorded == 0 {
+		return bannedDecision(ban, cfg, time.UnixMilli(nowMs)), Ban{}, nil
+	}
+	return deniedIntoBan(decision, ban, cfg, time.UnixMilli(nowMs)), ban, nil
+}
+
+// validateRedisRequest checks key and cfg and returns the interval in
+// milliseconds, the resolution the Lua scripts work with.
+func validateRedisRequest(key string, cfg BucketConfig) (int64, error) {
+	if key == "" {
+		return 0, errors.New("key cannot be empty")
+	}
+	if cfg.Interval <= 0 {
+		return 0, errors.New("interval must be greater than 0")
+	}
+	if cfg.Capacity <= 0 || cfg.RefillRate <= 0 {
+		return 0, errors.New("capacity and refill rate must be greater than 0")
+	}
+	intervalMs := cfg.Interval.Milliseconds()
+	if intervalMs <= 0 {
+		return 0, errors.New("interval must be at least 1ms")
+	}
+	return intervalMs, nil
 }
 
 func (s *RedisStore) DeleteInactiveBuckets(_ time.Time) error {
@@ -113,8 +227,26 @@ func (s *RedisStore) Close() error {
 	return nil
 }
 
-func (s *RedisStore) prefixedKey(key string) string {
-	return s.prefix + key
+// bucketKey returns the Redis key of key's bucket: the prefix followed by
+// key in braces. The braces make key the hash tag, so in Redis Cluster the
+// bucket, access list and ban of a key hash to the same slot and a single
+// EVAL can touch all three. "}" is escaped so that it cannot end the tag
+// early.
+func (s *RedisStore) bucketKey(key string) string {
+	return s.prefix + "{" + redisTagEscaper.Replace(key) + "}"
+}
+
+var (
+	redisTagEscaper   = strings.NewReplacer("%", "%25", "}", "%7D")
+	redisTagUnescaper = strings.NewReplacer("%25", "%", "%7D", "}")
+)
+
+// redisFlag encodes b as a script argument.
+func redisFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func toInt64(v interface{}) (int64, error) {
@@ -3,6 +3,10 @@ package core
 import (
 	"context"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -17,47 +21,121 @@ type fakeRedisEntry struct {
 }
 
 type fakeRedisEvalClient struct {
-	mu   sync.Mutex
-	data map[string]fakeRedisEntry
+	mu      sync.Mutex
+	data    map[string]fakeRedisEntry
+	hashes  map[string]map[string]string
+	strings map[string]string
+
+	// evals counts Eval calls and lastKeys holds the keys of the last one.
+	evals    int
+	lastKeys []string
+
+	// cluster makes Eval fail, as Redis Cluster does, when its keys are
+	// not all in the same hash slot.
+	cluster bool
+
+	// serverMs emulates the Redis server clock used for key expiry. It only
+	// moves forward, regardless of the timestamps sent by clients.
+	serverMs int64
 }
 
 func newFakeRedisEvalClient() *fakeRedisEvalClient {
 	return &fakeRedisEvalClient{
-		data: make(map[string]fakeRedisEntry),
+		data:    make(map[string]fakeRedisEntry),
+		hashes:  make(map[string]map[string]string),
+		strings: make(map[string]string),
 	}
 }
 
-func (c *fakeRedisEvalClient) Eval(_ context.Context, _ string, keys []string, args ...any) (any, error) {
-	if len(keys) != 1 {
-		return nil, fmt.Errorf("expected one key")
-	}
-	if len(args) != 5 {
-		return nil, fmt.Errorf("expected five args")
+// newFakeRedisClusterClient returns a fake that rejects cross-slot EVALs.
+func newFakeRedisClusterClient() *fakeRedisEvalClient {
+	c := newFakeRedisEvalClient()
+	c.cluster = true
+	return c
+}
+
+// redisHashTag returns the part of key Redis Cluster hashes: the first
+// non-empty {tag}, or the whole key.
+func redisHashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
 	}
+	return key
+}
 
-	capacity := toInt64OrZero(args[0])
-	refillRate := toInt64OrZero(args[1])
-	intervalMs := toInt64OrZero(args[2])
-	nowMs := toInt64OrZero(args[3])
-	ttlMs := toInt64OrZero(args[4])
-	key := keys[0]
+func (c *fakeRedisEvalClient) Eval(_ context.Context, script string, keys []string, args ...any) (any, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("expected a key")
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.evals++
+	c.lastKeys = keys
 
-	entry, ok := c.data[key]
-	if ok && entry.expiresAtMs > 0 && nowMs >= entry.expiresAtMs {
-		delete(c.data, key)
-		ok = false
+	if c.cluster {
+		for _, key := range keys[1:] {
+			if redisHashTag(key) != redisHashTag(keys[0]) {
+				return nil, fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot")
+			}
+		}
 	}
 
-	if !ok {
-		entry = fakeRedisEntry{
-			tokens:       capacity,
-			lastRefillMs: nowMs,
+	switch script {
+	case tokenBucketRedisLua:
+		return c.evalTokenBucket(keys, args)
+	case redisPeekLua:
+		return c.evalPeek(keys[0], args)
+	case redisSetLua:
+		return c.evalSet(keys[0], args)
+	case redisDeleteLua:
+		_, ok := c.data[keys[0]]
+		_, isHash := c.hashes[keys[0]]
+		_, isString := c.strings[keys[0]]
+		delete(c.data, keys[0])
+		delete(c.hashes, keys[0])
+		delete(c.strings, keys[0])
+		if ok || isHash || isString {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case redisAccessListGetLua:
+		return c.strings[keys[0]], nil
+	case redisAccessListSetLua:
+		c.strings[keys[0]] = args[0].(string)
+		return "OK", nil
+	case redisBanLua:
+		return c.evalBan(keys[0], args)
+	case redisBanGetLua:
+		h, ok := c.hashes[keys[0]]
+		if !ok {
+			return []any{nil, nil}, nil
 		}
+		return []any{h["level"], h["banned_until_ms"]}, nil
+	default:
+		return nil, fmt.Errorf("unknown script")
+	}
+}
+
+// lookup returns the live entry for key, advancing the server clock to nowMs
+// and dropping the entry if it has expired. The caller must hold c.mu.
+func (c *fakeRedisEvalClient) lookup(key string, nowMs int64) (fakeRedisEntry, bool) {
+	if nowMs > c.serverMs {
+		c.serverMs = nowMs
+	}
+
+	entry, ok := c.data[key]
+	if ok && entry.expiresAtMs > 0 && c.serverMs >= entry.expiresAtMs {
+		delete(c.data, key)
+		ok = false
 	}
+	return entry, ok
+}
 
+func fakeRefill(entry fakeRedisEntry, nowMs, capacity, refillRate, intervalMs int64) fakeRedisEntry {
+	entry.tokens = min(capacity, entry.tokens)
 	elapsed := nowMs - entry.lastRefillMs
 	if elapsed > 0 {
 		newTokens := (elapsed * refillRate) / intervalMs
@@ -73,6 +151,42 @@ func (c *fakeRedisEvalClient) Eval(_ context.Context, _ string, keys []string, a
 			entry.lastRefillMs += consumedMs
 		}
 	}
+	return entry
+}
+
+func (c *fakeRedisEvalClient) evalTokenBucket(keys []string, args []any) (any, error) {
+	if want := []int{5, 0, 14}[len(keys)-1]; len(args) != want {
+		return nil, fmt.Errorf("expected %d args", want)
+	}
+	key := keys[0]
+
+	capacity := toInt64OrZero(args[0])
+	refillRate := toInt64OrZero(args[1])
+	intervalMs := toInt64OrZero(args[2])
+	nowMs := toInt64OrZero(args[3])
+	ttlMs := toInt64OrZero(args[4])
+
+	if len(keys) > 1 && toInt64OrZero(args[5]) == 1 {
+		if list, ok := c.strings[keys[1]]; ok {
+			return []any{int64(0), int64(0), list}, nil
+		}
+	}
+	if len(keys) > 2 && toInt64OrZero(args[6]) == 1 {
+		h := c.hashes[keys[2]]
+		if until, _ := strconv.ParseInt(h["banned_until_ms"], 10, 64); until > nowMs {
+			level, _ := strconv.ParseInt(h["level"], 10, 64)
+			return []any{int64(0), int64(0), level, until, int64(0)}, nil
+		}
+	}
+
+	entry, ok := c.lookup(key, nowMs)
+	if !ok {
+		entry = fakeRedisEntry{
+			tokens:       capacity,
+			lastRefillMs: nowMs,
+		}
+	}
+	entry = fakeRefill(entry, nowMs, capacity, refillRate, intervalMs)
 
 	allowed := int64(0)
 	if entry.tokens > 0 {
@@ -82,13 +196,149 @@ func (c *fakeRedisEvalClient) Eval(_ context.Context, _ string, keys []string, a
 
 	entry.lastSeenMs = nowMs
 	if ttlMs > 0 {
-		entry.expiresAtMs = nowMs + ttlMs
+		entry.expiresAtMs = c.serverMs + ttlMs
 	}
 	c.data[key] = entry
 
+	if allowed == 0 && len(keys) > 2 && toInt64OrZero(args[7]) == 1 {
+		ban, err := c.evalBan(keys[2], append([]any{nowMs}, args[8:]...))
+		if err != nil {
+			return nil, err
+		}
+		return append([]any{int64(0), entry.tokens}, append(ban.([]any), int64(1))...), nil
+	}
 	return []any{allowed, entry.tokens}, nil
 }
 
+func (c *fakeRedisEvalClient) evalPeek(key string, args []any) (any, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("expected four args")
+	}
+
+	capacity := toInt64OrZero(args[0])
+	refillRate := toInt64OrZero(args[1])
+	intervalMs := toInt64OrZero(args[2])
+	nowMs := toInt64OrZero(args[3])
+
+	entry, ok := c.lookup(key, nowMs)
+	if !ok {
+		return []any{capacity, int64(0)}, nil
+	}
+	entry = fakeRefill(entry, nowMs, capacity, refillRate, intervalMs)
+	return []any{entry.tokens, int64(1)}, nil
+}
+
+func (c *fakeRedisEvalClient) evalSet(key string, args []any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("expected three args")
+	}
+
+	tokens := toInt64OrZero(args[0])
+	nowMs := toInt64OrZero(args[1])
+	ttlMs := toInt64OrZero(args[2])
+
+	c.lookup(key, nowMs)
+	entry := fakeRedisEntry{
+		tokens:       tokens,
+		lastRefillMs: nowMs,
+		lastSeenMs:   nowMs,
+	}
+	if ttlMs > 0 {
+		entry.expiresAtMs = c.serverMs + ttlMs
+	}
+	c.data[key] = entry
+	return int64(1), nil
+}
+
+// evalBan mirrors redis_ban_script.lua. Ban state never expires in the
+// fake; the script's PEXPIRE only saves memory.
+func (c *fakeRedisEvalClient) evalBan(key string, args []any) (any, error) {
+	if len(args) != 7 {
+		return nil, fmt.Errorf("expected seven args")
+	}
+	nowMs := toInt64OrZero(args[0])
+	threshold := toInt64OrZero(args[1])
+	windowMs := toInt64OrZero(args[2])
+	durationMs := toInt64OrZero(args[3])
+	multiplier := args[4].(float64)
+	maxDurationMs := toInt64OrZero(args[5])
+	decayMs := toInt64OrZero(args[6])
+
+	h := c.hashes[key]
+	if h == nil {
+		h = map[string]string{"window_start_ms": strconv.FormatInt(nowMs, 10)}
+		c.hashes[key] = h
+	}
+	field := func(name string) int64 {
+		n, _ := strconv.ParseInt(h[name], 10, 64)
+		return n
+	}
+	denials, windowStartMs, level, bannedUntilMs := field("denials"), field("window_start_ms"), field("level"), field("banned_until_ms")
+
+	if bannedUntilMs > nowMs {
+		return []any{level, bannedUntilMs}, nil
+	}
+	if level > 0 && nowMs-bannedUntilMs >= decayMs {
+		level = 0
+	}
+	if nowMs-windowStartMs >= windowMs {
+		denials, windowStartMs = 0, nowMs
+	}
+	denials++
+	if denials > threshold {
+		level++
+		banMs := min(float64(durationMs)*math.Pow(multiplier, float64(level-1)), float64(maxDurationMs))
+		bannedUntilMs = nowMs + int64(banMs)
+		denials, windowStartMs = 0, nowMs
+	}
+
+	h["denials"] = strconv.FormatInt(denials, 10)
+	h["window_start_ms"] = strconv.FormatInt(windowStartMs, 10)
+	h["level"] = strconv.FormatInt(level, 10)
+	h["banned_until_ms"] = strconv.FormatInt(bannedUntilMs, 10)
+	return []any{level, bannedUntilMs}, nil
+}
+
+// Scan pages through live keys in sorted order; the cursor is an offset.
+// match may hold one "*"; the rest is matched literally.
+func (c *fakeRedisEvalClient) Scan(_ context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix, suffix, _ := strings.Cut(match, "*")
+	matches := func(key string) bool {
+		return len(key) >= len(prefix)+len(suffix) && strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix)
+	}
+	var keys []string
+	for key, entry := range c.data {
+		if entry.expiresAtMs > 0 && c.serverMs >= entry.expiresAtMs {
+			continue
+		}
+		if matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range c.hashes {
+		if matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range c.strings {
+		if matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	start := min(int(cursor), len(keys))
+	end := min(start+int(count), len(keys))
+	next := uint64(end)
+	if end == len(keys) {
+		next = 0
+	}
+	return keys[start:end], next, nil
+}
+
 func toInt64OrZero(v any) int64 {
 	switch t := v.(type) {
 	case int64:
@@ -237,3 +487,39 @@ func TestRedisStoreCrossInstanceSimulation(t *testing.T) {
 		t.Fatal("expected fourth request across instances to be blocked")
 	}
 }
+
+func TestRedisStoreKeyLayoutRoundTrips(t *testing.T) {
+	client := newFakeRedisClusterClient()
+	s, err := NewRedisStore(client, RedisStoreOptions{KeyPrefix: "rl:", AccessLists: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+	policy := BanPolicy{Threshold: 1, Window: time.Minute, Duration: time.Minute}
+	if err := policy.normalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const key = "}a{b}%7D"
+	if _, err := s.Allow(key, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.SetAccessList(key, AccessAllow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for range policy.Threshold + 1 {
+		if _, err := s.RecordDenial(key, policy); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if keys, _, err := s.ListKeys("", 10); err != nil || len(keys) != 1 || keys[0] != key {
+		t.Fatalf("expected only the bucket to be listed as %q, got %q, %v", key, keys, err)
+	}
+	if lists, err := s.AccessLists(); err != nil || len(lists) != 1 || lists[key] != AccessAllow {
+		t.Fatalf("unexpected lists %v, %v", lists, err)
+	}
+	if bans, err := s.Bans(); err != nil || len(bans) != 1 || bans[0].Key != key {
+		t.Fatalf("unexpected bans %+v, %v", bans, err)
+	}
+}
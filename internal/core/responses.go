@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeProblem = "application/problem+json"
+	contentTypeText    = "text/plain"
+)
+
+// WithDenialHandler replaces the response written when a request is
+// denied. Rate limit headers and Retry-After are already set when fn runs;
+// fn writes the status and body. Denylisted keys are denied with
+// Decision.Access set to AccessDeny.
+func WithDenialHandler(fn func(w http.ResponseWriter, r *http.Request, d Decision)) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.onDenied = fn
+	}
+}
+
+// WithErrorHandler replaces the response written when the store fails.
+func WithErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.onError = fn
+	}
+}
+
+// WithProblemDetails makes RFC 9457 application/problem+json the preferred
+// body for denials and store errors. Clients can still ask for
+// application/json or text/plain through Accept.
+func WithProblemDetails() MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.problemDetails = true
+	}
+}
+
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// RetryAfter is an extension member, in seconds.
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+// writeDenied writes the default 429 response in the format negotiated
+// from Accept. Without an Accept preference the legacy JSON body is used,
+// or problem+json with WithProblemDetails.
+func (c *middlewareConfig) writeDenied(w http.ResponseWriter, r *http.Request, d Decision) {
+	offers := []string{contentTypeJSON, contentTypeProblem, contentTypeText}
+	if c.problemDetails {
+		offers = []string{contentTypeProblem, contentTypeJSON, contentTypeText}
+	}
+
+	retryAfter := durationCeilSeconds(d.RetryAfter)
+	problem := problemDetails{
+		Type:       "about:blank",
+		Title:      http.StatusText(http.StatusTooManyRequests),
+		Status:     http.StatusTooManyRequests,
+		Detail:     "rate limit exceeded",
+		RetryAfter: retryAfter,
+	}
+	if retryAfter > 0 {
+		problem.Detail = "rate limit exceeded, retry after " + strconv.FormatInt(retryAfter, 10) + "s"
+	}
+	writeNegotiated(w, r, offers, problem, "rate limit exceeded")
+}
+
+// writeForbidden writes the default 403 response for denylisted keys.
+func (c *middlewareConfig) writeForbidden(w http.ResponseWriter, r *http.Request) {
+	offers := []string{contentTypeJSON, contentTypeProblem, contentTypeText}
+	if c.problemDetails {
+		offers = []string{contentTypeProblem, contentTypeJSON, contentTypeText}
+	}
+
+	problem := problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusForbidden),
+		Status: http.StatusForbidden,
+		Detail: "access denied",
+	}
+	writeNegotiated(w, r, offers, problem, "access denied")
+}
+
+// writeError writes the default 500 response. The store error is not
+// exposed to clients. Without an Accept preference it is plain text, or
+// problem+json with WithProblemDetails.
+func (c *middlewareConfig) writeError(w http.ResponseWriter, r *http.Request) {
+	offers := []string{contentTypeText, contentTypeJSON, contentTypeProblem}
+	if c.problemDetails {
+		offers = []string{contentTypeProblem, contentTypeJSON, contentTypeText}
+	}
+
+	problem := problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+		Detail: "rate limiter error",
+	}
+	writeNegotiated(w, r, offers, problem, "rate limiter error")
+}
+
+func writeNegotiated(w http.ResponseWriter, r *http.Request, offers []string, problem problemDetails, message string) {
+	w.Header().Add("Vary", "Accept")
+
+	switch negotiate(r.Header.Get("Accept"), offers) {
+	case contentTypeProblem:
+		w.Header().Set("Content-Type", contentTypeProblem)
+		w.WriteHeader(problem.Status)
+		_ = json.NewEncoder(w).Encode(problem)
+	case contentTypeJSON:
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(problem.Status)
+		_, _ = fmt.Fprintf(w, `{"error":%q}`, message)
+	default:
+		http.Error(w, message, problem.Status)
+	}
+}
+
+// negotiate picks the offer the Accept header prefers most, breaking ties
+// by the order of offers. It falls back to the first offer when nothing
+// matches, so clients always get a body.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value accept gives offer, taken from the most
+// specific matching media range.
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		var s int
+		switch {
+		case mediaType == offer:
+			s = 2
+		case mediaType == offerType+"/*":
+			s = 1
+		case mediaType == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+		q = 1
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func denyingHandler(t *testing.T, opts ...MiddlewareOption) http.Handler {
+	t.Helper()
+	m, err := NewManager(1, 1, time.Minute, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(m.Close)
+	m.Allow("k")
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	return m.Middleware(func(*http.Request) string { return "k" }, opts...)(ok)
+}
+
+func failingHandler(t *testing.T, opts ...MiddlewareOption) http.Handler {
+	t.Helper()
+	store, err := NewRedisStore(FailingRedisEvalClient{}, RedisStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(store, 1, 1, time.Minute, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(m.Close)
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	return m.Middleware(func(*http.Request) string { return "k" }, opts...)(ok)
+}
+
+func serveWithAccept(handler http.Handler, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareDefaultResponsesUnchanged(t *testing.T) {
+	rec := serveWithAccept(denyingHandler(t), "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != `{"error":"rate limit exceeded"}` {
+		t.Fatalf("unexpected default denial %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	rec = serveWithAccept(failingHandler(t), "")
+	if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || strings.TrimSpace(rec.Body.String()) != "rate limiter error" {
+		t.Fatalf("unexpected default error %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
+
+func TestMiddlewareProblemDetails(t *testing.T) {
+	rec := serveWithAccept(denyingHandler(t, WithProblemDetails()), "")
+	if rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected problem+json, got %q", rec.Header().Get("Content-Type"))
+	}
+	var p problemDetails
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("invalid problem body: %v", err)
+	}
+	if p.Status != http.StatusTooManyRequests || p.Type != "about:blank" || p.Title != "Too Many Requests" || p.RetryAfter != 60 {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+
+	rec = serveWithAccept(failingHandler(t, WithProblemDetails()), "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil || p.Status != http.StatusInternalServerError {
+		t.Fatalf("unexpected error problem %+v, %v", p, err)
+	}
+	if strings.Contains(rec.Body.String(), "redis unavailable") {
+		t.Fatal("expected the store error not to be exposed")
+	}
+}
+
+func TestMiddlewareNegotiatesResponseFormat(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"application/problem+json", "application/problem+json"},
+		{"text/plain", "text/plain; charset=utf-8"},
+		{"text/*;q=0.9, application/json;q=0.5", "text/plain; charset=utf-8"},
+		{"application/*", "application/json"},
+		{"*/*", "application/json"},
+		{"image/png", "application/json"},
+		{"application/json;q=0, */*", "application/problem+json"},
+	}
+	handler := denyingHandler(t)
+	for _, c := range cases {
+		rec := serveWithAccept(handler, c.accept)
+		if got := rec.Header().Get("Content-Type"); got != c.want {
+			t.Fatalf("Accept %q: expected %q, got %q", c.accept, c.want, got)
+		}
+		if rec.Header().Get("Vary") != "Accept" {
+			t.Fatalf("Accept %q: expected Vary: Accept", c.accept)
+		}
+	}
+}
+
+func TestMiddlewareCustomHandlers(t *testing.T) {
+	rec := serveWithAccept(denyingHandler(t, WithDenialHandler(func(w http.ResponseWriter, _ *http.Request, d Decision) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("slow down"))
+		if d.Allowed {
+			t.Error("expected a denied decision")
+		}
+	})), "")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "slow down" || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("unexpected custom denial %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	var got error
+	rec = serveWithAccept(failingHandler(t, WithErrorHandler(func(w http.ResponseWriter, _ *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusBadGateway)
+	})), "")
+	if rec.Code != http.StatusBadGateway || got == nil || !strings.Contains(got.Error(), "redis unavailable") {
+		t.Fatalf("unexpected custom error response %d, %v", rec.Code, got)
+	}
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRouterBucketTTL       = 10 * time.Minute
+	defaultRouterCleanupInterval = time.Minute
+)
+
+// MatchMode controls how many rules a Router applies to a request.
+type MatchMode int
+
+const (
+	// MatchFirst applies only the first rule matching the request.
+	MatchFirst MatchMode = iota
+	// MatchAll applies every matching rule, in order; the request is denied
+	// if any of them denies it. Rules whose bucket is already empty are
+	// decided first, so a request they deny does not spend tokens of the
+	// others.
+	MatchAll
+)
+
+// Rule is one rate limit policy of a Router.
+type Rule struct {
+	// Name is the rule's policy name, used in metrics, logs and headers and
+	// to namespace its keys in the shared store. Required and unique.
+	Name string
+	// Pattern selects requests as "[METHOD ]PATH". PATH is matched with
+	// path.Match, so "*" matches within one segment, and a trailing "/*"
+	// matches the prefix and everything below it. Examples: "POST /login",
+	// "GET /api/*", "/users/*/avatar".
+	Pattern string
+	Config  BucketConfig
+	// Key extracts the bucket key. Defaults to RemoteIP.
+	Key func(*http.Request) string
+	// Options are applied to the rule's Manager after RouterOptions.Options.
+	Options []ManagerOption
+
+	method string
+	path   string
+	prefix bool
+}
+
+type RouterOptions struct {
+	// Store is shared by all rules. Defaults to a new MemoryStore. The
+	// Router closes it on Close.
+	Store Store
+	Rules []Rule
+	Match MatchMode
+	// BucketTTL and CleanupInterval configure every rule's Manager. The
+	// Router cleans up the shared store in one loop for all rules.
+	// Defaults to 10 minutes and 1 minute.
+	BucketTTL       time.Duration
+	CleanupInterval time.Duration
+	// Options are applied to every rule's Manager, e.g. WithMetrics.
+	Options []ManagerOption
+	// Middleware configures headers and responses for all rules.
+	Middleware []MiddlewareOption
+}
+
+// Router is a middleware that picks rate limit rules by method and path,
+// each backed by its own Manager on a shared Store.
+type Router struct {
+	store    Store
+	rules    []Rule
+	managers []*Manager
+	match    MatchMode
+	cfg      middlewareConfig
+
+	bucketTTL       time.Duration
+	cleanupInterval time.Duration
+	stopCh          chan struct{}
+	wg              sync.WaitGroup
+	stopOnce        sync.Once
+	closeErr        error
+}
+
+func NewRouter(opts RouterOptions) (*Router, error) {
+	if len(opts.Rules) == 0 {
+		return nil, errors.New("router needs at least one rule")
+	}
+	if opts.Match != MatchFirst && opts.Match != MatchAll {
+		return nil, errors.New("invalid match mode")
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	bucketTTL := opts.BucketTTL
+	if bucketTTL <= 0 {
+		bucketTTL = defaultRouterBucketTTL
+	}
+	cleanupInterval := opts.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = defaultRouterCleanupInterval
+	}
+
+	rt := &Router{
+		store:           store,
+		match:           opts.Match,
+		cfg:             newMiddlewareConfig(opts.Middleware),
+		bucketTTL:       bucketTTL,
+		cleanupInterval: cleanupInterval,
+		stopCh:          make(chan struct{}),
+	}
+	seen := make(map[string]bool)
+	for _, rule := range opts.Rules {
+		if err := rule.parse(); err != nil {
+			rt.closeManagers()
+			return nil, err
+		}
+		if seen[rule.Name] {
+			rt.closeManagers()
+			return nil, fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.Key == nil {
+			rule.Key = RemoteIP
+		}
+
+		managerOpts := append([]ManagerOption{WithPolicyName(rule.Name)}, opts.Options...)
+		managerOpts = append(managerOpts, rule.Options...)
+		managerOpts = append(managerOpts, func(m *Manager) {
+			m.sharedStore = true
+			m.keyPrefix = rule.Name + ":"
+		})
+		m, err := NewManagerWithStore(store, rule.Config.Capacity, rule.Config.RefillRate, rule.Config.Interval,
+			bucketTTL, cleanupInterval, managerOpts...)
+		if err != nil {
+			rt.closeManagers()
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		rt.rules = append(rt.rules, rule)
+		rt.managers = append(rt.managers, m)
+	}
+
+	rt.wg.Add(1)
+	go rt.cleanupLoop()
+	return rt, nil
+}
+
+func (rule *Rule) parse() error {
+	if rule.Name == "" {
+		return errors.New("rule name cannot be empty")
+	}
+	method, p, ok := strings.Cut(strings.TrimSpace(rule.Pattern), " ")
+	if !ok {
+		method, p = "", method
+	}
+	p = strings.TrimSpace(p)
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("rule %q: pattern path must start with /", rule.Name)
+	}
+	if rest, found := strings.CutSuffix(p, "/*"); found {
+		rule.prefix = true
+		p = rest
+	}
+	if _, err := path.Match(p, "/"); err != nil {
+		return fmt.Errorf("rule %q: invalid pattern: %w", rule.Name, err)
+	}
+	rule.method = strings.ToUpper(method)
+	rule.path = p
+	return nil
+}
+
+func (rule *Rule) matches(r *http.Request) bool {
+	if rule.method != "" && rule.method != r.Method {
+		return false
+	}
+	p := r.URL.Path
+	if rule.prefix {
+		if rule.path == "" {
+			return true
+		}
+		// Match the prefix against as many leading segments as it has.
+		n := strings.Count(rule.path, "/")
+		segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		if len(segments) < n {
+			return false
+		}
+		p = "/" + strings.Join(segments[:n], "/")
+	}
+	ok, _ := path.Match(rule.path, p)
+	return ok
+}
+
+// Manager returns the Manager of the named rule, e.g. for AdminHandler, or
+// nil if there is no such rule.
+func (rt *Router) Manager(name string) *Manager {
+	for i, rule := range rt.rules {
+		if rule.Name == name {
+			return rt.managers[i]
+		}
+	}
+	return nil
+}
+
+// SetAccessList puts key on list for every rule. A rule's keys are prefixed
+// with its name, so lists set through a rule's Manager need the prefix, e.g.
+// "login:1.2.3.4"; this applies the bare key to all rules at once.
+func (rt *Router) SetAccessList(key string, list AccessList) error {
+	var errs []error
+	for i, rule := range rt.rules {
+		if err := rt.managers[i].SetAccessList(rule.Name+":"+key, list); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Unban lifts key's ban and resets its escalation for every rule with
+// WithBans. As with SetAccessList, key is given without the rule prefix.
+func (rt *Router) Unban(key string) error {
+	var errs []error
+	for i, rule := range rt.rules {
+		m := rt.managers[i]
+		if m.bans == nil {
+			continue
+		}
+		if err := m.Unban(rule.Name + ":" + key); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// routerMatch is a rule matching a request, with the request's key for it.
+type routerMatch struct {
+	rule int
+	key  string
+}
+
+// Middleware applies the matching rules to each request. Requests matching
+// no rule, or skipped with WithSkip, are not limited.
+func (rt *Router) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt.cfg.skip != nil && rt.cfg.skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var (
+			reported    *Manager
+			reportedKey string
+			decision    Decision
+		)
+		for _, match := range rt.matching(r) {
+			m, key := rt.managers[match.rule], match.key
+			d, err := m.decide(r.Context(), key, rt.cfg.dryRun || m.dryRun)
+			if err != nil || !d.Allowed {
+				rt.cfg.respond(w, r, m, key, d, err)
+				return
+			}
+			// Report the rule closest to denying the request. Allowlisted
+			// keys have no bucket to report on.
+			if d.Access != AccessAllow && (reported == nil || (d.WouldDeny && !decision.WouldDeny) ||
+				(d.WouldDeny == decision.WouldDeny && d.Remaining < decision.Remaining)) {
+				reported, reportedKey, decision = m, key, d
+			}
+		}
+		if reported != nil && !rt.cfg.respond(w, r, reported, reportedKey, decision, nil) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matching returns the rules r matches: the first one under MatchFirst, and
+// all of them under MatchAll, with those whose bucket is already empty
+// moved to the front. Buckets can still run out between that check and the
+// decision, so MatchAll only avoids spending tokens on a request that is
+// certain to be denied.
+func (rt *Router) matching(r *http.Request) []routerMatch {
+	var matches []routerMatch
+	for i := range rt.rules {
+		rule := &rt.rules[i]
+		if !rule.matches(r) {
+			continue
+		}
+		matches = append(matches, routerMatch{rule: i, key: rule.Name + ":" + rule.Key(r)})
+		if rt.match == MatchFirst {
+			return matches
+		}
+	}
+	if len(matches) < 2 {
+		return matches
+	}
+	if _, ok := rt.store.(StateStore); !ok {
+		return matches
+	}
+	empty := make([]bool, len(matches))
+	for i, match := range matches {
+		d, err := rt.managers[match.rule].Peek(match.key)
+		empty[i] = err == nil && !d.Allowed
+	}
+	ordered := make([]routerMatch, 0, len(matches))
+	for i, match := range matches {
+		if empty[i] {
+			ordered = append(ordered, match)
+		}
+	}
+	for i, match := range matches {
+		if !empty[i] {
+			ordered = append(ordered, match)
+		}
+	}
+	return ordered
+}
+
+func (rt *Router) cleanupLoop() {
+	defer rt.wg.Done()
+
+	ticker := time.NewTicker(rt.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rt.Cleanup()
+		case <-rt.stopCh:
+			return
+		}
+	}
+}
+
+// Cleanup removes the shared store's inactive buckets once for all rules
+// and runs each rule's Manager through the rest of its cleanup.
+func (rt *Router) Cleanup() {
+	now := time.Now()
+	cutoff := now.Add(-rt.bucketTTL)
+	err := rt.store.DeleteInactiveBuckets(cutoff)
+	for _, m := range rt.managers {
+		m.finishCleanup(now, cutoff, err)
+	}
+}
+
+// Close stops the cleanup loop and every rule's Manager, and closes the
+// shared store. Later calls return the result of the first.
+func (rt *Router) Close() error {
+	rt.stopOnce.Do(func() {
+		close(rt.stopCh)
+		rt.wg.Wait()
+		rt.closeManagers()
+		rt.closeErr = rt.store.Close()
+	})
+	return rt.closeErr
+}
+
+func (rt *Router) closeManagers() {
+	for _, m := range rt.managers {
+		m.Close()
+	}
+}
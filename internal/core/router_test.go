@@ -0,0 +1,275 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func routerRequest(handler http.Handler, method, target, remote string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func newTestRouter(t *testing.T, match MatchMode) (*Router, http.Handler) {
+	t.Helper()
+	rt, err := NewRouter(RouterOptions{
+		Match: match,
+		Rules: []Rule{
+			{Name: "login", Pattern: "POST /login", Config: BucketConfig{Capacity: 2, RefillRate: 2, Interval: time.Minute}},
+			{
+				Name:    "api",
+				Pattern: "GET /api/*",
+				Config:  BucketConfig{Capacity: 3, RefillRate: 3, Interval: time.Second},
+				Key:     func(r *http.Request) string { return r.Header.Get("X-API-Key") },
+			},
+			{Name: "global", Pattern: "/*", Config: BucketConfig{Capacity: 5, RefillRate: 5, Interval: time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	return rt, rt.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+}
+
+func TestRouterFirstMatch(t *testing.T) {
+	_, handler := newTestRouter(t, MatchFirst)
+
+	for i := range 2 {
+		if rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:1"); rec.Code != http.StatusOK {
+			t.Fatalf("login %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:2")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Fatalf("expected login rule to deny per IP, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.2:1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected another IP to have its own bucket, got %d", rec.Code)
+	}
+
+	// GET /login only matches the catch-all rule.
+	if rec := routerRequest(handler, http.MethodGet, "/login", "192.0.2.1:1"); rec.Header().Get("X-RateLimit-Limit") != "5" {
+		t.Fatalf("expected the global rule, got %v", rec.Header())
+	}
+	if rec := routerRequest(handler, http.MethodGet, "/api/items/1", "192.0.2.1:1"); rec.Header().Get("X-RateLimit-Limit") != "3" {
+		t.Fatalf("expected the api rule for nested paths, got %v", rec.Header())
+	}
+	if rec := routerRequest(handler, http.MethodGet, "/apix", "192.0.2.1:1"); rec.Header().Get("X-RateLimit-Limit") != "5" {
+		t.Fatalf("expected /apix not to match /api/*, got %v", rec.Header())
+	}
+}
+
+func TestRouterAllMatch(t *testing.T) {
+	rt, handler := newTestRouter(t, MatchAll)
+
+	for i := range 2 {
+		rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, rec.Code)
+		}
+		// The login rule has fewer tokens left, so it is reported.
+		if rec.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("expected the most restrictive rule in headers, got %v", rec.Header())
+		}
+	}
+	if rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected login rule to deny, got %d", rec.Code)
+	}
+
+	// Both rules consumed a token for the first two requests, and the
+	// denied request stopped before the global rule.
+	if d, _ := rt.Manager("global").Peek("global:192.0.2.1"); d.Remaining != 3 {
+		t.Fatalf("expected the global rule to have been applied twice, got %+v", d)
+	}
+}
+
+func TestRouterAllMatchDecidesEmptyRulesFirst(t *testing.T) {
+	rt, err := NewRouter(RouterOptions{
+		Match: MatchAll,
+		Rules: []Rule{
+			{Name: "global", Pattern: "/*", Config: BucketConfig{Capacity: 5, RefillRate: 5, Interval: time.Minute}},
+			{Name: "login", Pattern: "POST /login", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rt.Close()
+	handler := rt.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		if rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:1"); rec.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i+1, want, rec.Code)
+		}
+	}
+	// The login rule was already empty for the denied requests, so the
+	// global rule before it only paid for the first one.
+	if d, _ := rt.Manager("global").Peek("global:192.0.2.1"); d.Remaining != 4 {
+		t.Fatalf("expected denied requests not to spend global tokens, got %+v", d)
+	}
+}
+
+func TestRouterSetAccessListAppliesToAllRules(t *testing.T) {
+	rt, handler := newTestRouter(t, MatchFirst)
+
+	if err := rt.SetAccessList("192.0.2.1", AccessDeny); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{"/login", "/other"} {
+		if rec := routerRequest(handler, http.MethodPost, path, "192.0.2.1:1"); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected denylisted key to be rejected, got %d", path, rec.Code)
+		}
+	}
+	if list, _ := rt.Manager("login").AccessList("login:192.0.2.1"); list != AccessDeny {
+		t.Fatalf("expected the rule's prefixed key to be listed, got %v", list)
+	}
+	if err := rt.SetAccessList("192.0.2.1", AccessNone); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec := routerRequest(handler, http.MethodPost, "/login", "192.0.2.1:1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected key removed from the list to pass, got %d", rec.Code)
+	}
+}
+
+func TestRouterUnmatchedRequestsPassThrough(t *testing.T) {
+	rt, err := NewRouter(RouterOptions{Rules: []Rule{
+		{Name: "login", Pattern: "POST /login", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rt.Close()
+	handler := rt.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for range 3 {
+		rec := routerRequest(handler, http.MethodGet, "/", "192.0.2.1:1")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("expected unmatched request to pass without headers, got %d %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestRouterSharesStoreAndClosesItOnce(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rt, err := NewRouter(RouterOptions{
+		Store: store,
+		Rules: []Rule{
+			{Name: "a", Pattern: "/a", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+			{Name: "b", Pattern: "/b", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := rt.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	routerRequest(handler, http.MethodGet, "/a", "192.0.2.1:1")
+	routerRequest(handler, http.MethodGet, "/b", "192.0.2.1:1")
+
+	if n, _ := store.mem.CountKeys(); n != 2 {
+		t.Fatalf("expected both rules to use the shared store with separate keys, got %d keys", n)
+	}
+
+	if err := rt.Close(); err != nil {
+		t.Fatalf("expected the shared store to be closed once, got %v", err)
+	}
+}
+
+// cleanupCountingStore counts DeleteInactiveBuckets and Close calls.
+type cleanupCountingStore struct {
+	*MemoryStore
+	cleanups atomic.Int64
+	closes   atomic.Int64
+}
+
+func (s *cleanupCountingStore) Close() error {
+	s.closes.Add(1)
+	return s.MemoryStore.Close()
+}
+
+func (s *cleanupCountingStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	s.cleanups.Add(1)
+	return s.MemoryStore.DeleteInactiveBuckets(cutoff)
+}
+
+func TestRouterCleansUpSharedStoreOnce(t *testing.T) {
+	store := &cleanupCountingStore{MemoryStore: NewMemoryStore()}
+	metrics := NewMetrics()
+	rt, err := NewRouter(RouterOptions{
+		Store:           store,
+		BucketTTL:       time.Hour,
+		CleanupInterval: 10 * time.Millisecond,
+		Options:         []ManagerOption{WithMetrics(metrics)},
+		Rules: []Rule{
+			{Name: "a", Pattern: "/a", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+			{Name: "b", Pattern: "/b", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+			{Name: "c", Pattern: "/c", Config: BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := rt.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	routerRequest(handler, http.MethodGet, "/a", "192.0.2.1:1")
+	routerRequest(handler, http.MethodGet, "/a", "192.0.2.2:1")
+	routerRequest(handler, http.MethodGet, "/b", "192.0.2.1:1")
+
+	if n, err := rt.Manager("a").KeyCount(); err != nil || n != 2 {
+		t.Fatalf("expected a rule to count only its own keys, got %d, %v", n, err)
+	}
+	if page, err := rt.Manager("b").Keys("", 10); err != nil || len(page.Keys) != 1 || page.Keys[0].Key != "b:192.0.2.1" {
+		t.Fatalf("expected a rule to list only its own keys, got %+v, %v", page, err)
+	}
+
+	time.Sleep(55 * time.Millisecond)
+	var b strings.Builder
+	_ = metrics.Write(&b)
+	for range 2 {
+		if err := rt.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := store.closes.Load(); n != 1 {
+		t.Fatalf("expected the shared store to be closed once, got %d", n)
+	}
+	if n := store.cleanups.Load(); n < 1 || n > 6 {
+		t.Fatalf("expected one cleanup per interval for all rules, got %d", n)
+	}
+
+	for _, want := range []string{
+		`ratelimiter_tracked_keys{policy="a"} 2`,
+		`ratelimiter_tracked_keys{policy="b"} 1`,
+		`ratelimiter_tracked_keys{policy="c"} 0`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected %q in:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestNewRouterValidatesRules(t *testing.T) {
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Second}
+	for _, rules := range [][]Rule{
+		nil,
+		{{Pattern: "/a", Config: cfg}},
+		{{Name: "a", Pattern: "a", Config: cfg}},
+		{{Name: "a", Pattern: "/[", Config: cfg}},
+		{{Name: "a", Pattern: "/a", Config: cfg}, {Name: "a", Pattern: "/b", Config: cfg}},
+		{{Name: "a", Pattern: "/a"}},
+	} {
+		if _, err := NewRouter(RouterOptions{Rules: rules}); err == nil {
+			t.Fatalf("expected error for rules %+v", rules)
+		}
+	}
+}
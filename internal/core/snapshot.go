@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const snapshotVersion = 1
+
+// Snapshotter is implemented by stores whose state can be saved and loaded,
+// so buckets survive process restarts.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+type storeSnapshot struct {
+	Version int              `json:"version"`
+	TakenAt time.Time        `json:"taken_at"`
+	Buckets []bucketSnapshot `json:"buckets"`
+}
+
+type bucketSnapshot struct {
+	Key           string        `json:"key"`
+	Capacity      int64         `json:"capacity"`
+	TokenInterval time.Duration `json:"token_interval_ns"`
+	Tokens        int64         `json:"tokens"`
+	LastRefill    time.Time     `json:"last_refill"`
+	LastSeen      time.Time     `json:"last_seen"`
+}
+
+func (b bucketSnapshot) validate() error {
+	if b.Key == "" {
+		return errors.New("snapshot bucket has empty key")
+	}
+	if b.Capacity <= 0 || b.TokenInterval <= 0 || b.Tokens < 0 {
+		return fmt.Errorf("snapshot bucket %q has invalid state", b.Key)
+	}
+	return nil
+}
+
+// Snapshot writes the state of every bucket to w as versioned JSON.
+func (s *MemoryStore) Snapshot(w io.Writer) error {
+	now := s.nowNanos()
+	snap := storeSnapshot{
+		Version: snapshotVersion,
+		TakenAt: s.epoch.Add(time.Duration(now)),
+	}
+
+	s.forEachBucket(now, func(b bucketSnapshot) {
+		snap.Buckets = append(snap.Buckets, b)
+	})
+
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// forEachBucket calls fn with the state of every bucket at now, one shard at
+// a time.
+func (s *MemoryStore) forEachBucket(now int64, fn func(bucketSnapshot)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for _, e := range sh.buckets {
+			fn(s.snapshotEntry(e, now))
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// snapshotKey returns the current state of a single bucket.
+func (s *MemoryStore) snapshotKey(key string) (bucketSnapshot, bool) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	e, ok := sh.buckets[key]
+	if !ok {
+		return bucketSnapshot{}, false
+	}
+	return s.snapshotEntry(e, s.nowNanos()), true
+}
+
+func (s *MemoryStore) snapshotEntry(e *memoryEntry, now int64) bucketSnapshot {
+	tokens, lastRefill := e.bucket.state(now)
+	return bucketSnapshot{
+		Key:           e.key,
+		Capacity:      e.bucket.capacity,
+		TokenInterval: time.Duration(e.bucket.perToken),
+		Tokens:        tokens,
+		LastRefill:    s.epoch.Add(time.Duration(lastRefill)),
+		LastSeen:      s.epoch.Add(time.Duration(e.bucket.lastSeen.Load())),
+	}
+}
+
+// Restore loads buckets written by Snapshot, replacing existing buckets with
+// the same keys. Tokens that would have been refilled while the process was
+// down are credited on the next request. Under the RejectNewKeys policy,
+// buckets that do not fit are dropped.
+func (s *MemoryStore) Restore(r io.Reader) error {
+	var snap storeSnapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	for _, b := range snap.Buckets {
+		if err := b.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range snap.Buckets {
+		s.restoreEntry(b)
+	}
+	return nil
+}
+
+func (s *MemoryStore) restoreEntry(b bucketSnapshot) {
+	s.putEntry(b, false)
+}
+
+// mergeEntry restores b without losing state built up since b was taken: a
+// bucket already held for the key with the same config keeps the fewer tokens
+// and the later last-seen time of the two, and one with a different config is
+// kept if it was seen more recently.
+func (s *MemoryStore) mergeEntry(b bucketSnapshot) {
+	s.putEntry(b, true)
+}
+
+func (s *MemoryStore) putEntry(b bucketSnapshot, merge bool) {
+	e := &memoryEntry{key: b.Key}
+	e.bucket.restore(b.Capacity, int64(b.TokenInterval), b.Tokens,
+		int64(b.LastRefill.Sub(s.epoch)), int64(b.LastSeen.Sub(s.epoch)))
+	e.expireAt = e.bucket.lastSeen.Load()
+
+	sh := s.shard(b.Key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if old, ok := sh.buckets[b.Key]; ok {
+		if merge {
+			if old.bucket.capacity == e.bucket.capacity && old.bucket.perToken == e.bucket.perToken {
+				old.bucket.merge(&e.bucket)
+				return
+			}
+			if old.bucket.lastSeen.Load() >= e.bucket.lastSeen.Load() {
+				return
+			}
+		}
+		sh.remove(old)
+	}
+	s.add(sh, e)
+}
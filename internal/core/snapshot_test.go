@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreSnapshotRestore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	cfg := BucketConfig{Capacity: 3, RefillRate: 1, Interval: time.Second}
+
+	src := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock})
+	for range 3 {
+		_, _ = src.Allow("drained", cfg)
+	}
+	_, _ = src.Allow("partial", cfg)
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected snapshot error: %v", err)
+	}
+
+	// The restoring process starts 1.5s later with a different epoch.
+	now = now.Add(1500 * time.Millisecond)
+	dst := NewMemoryStoreWithOptions(MemoryStoreOptions{Now: clock})
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+
+	// One token was refilled during the downtime.
+	if d, _ := dst.Allow("drained", cfg); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected restored bucket to catch up one token, got %+v", d)
+	}
+	if d, _ := dst.Allow("drained", cfg); d.Allowed {
+		t.Fatal("expected restored bucket not to get a fresh burst")
+	}
+	// Half a second of refill progress carried over from the snapshot.
+	now = now.Add(500 * time.Millisecond)
+	if d, _ := dst.Allow("drained", cfg); !d.Allowed {
+		t.Fatal("expected partial refill progress to survive restore")
+	}
+	if d, _ := dst.Allow("partial", cfg); !d.Allowed || d.Remaining != 2 {
+		t.Fatalf("expected partial bucket to refill to capacity, got %+v", d)
+	}
+}
+
+func TestMemoryStoreRestoreRejectsUnknownVersion(t *testing.T) {
+	s := NewMemoryStore()
+	err := s.Restore(strings.NewReader(`{"version":99,"buckets":[]}`))
+	if err == nil {
+		t.Fatal("expected error for unknown snapshot version")
+	}
+}
+
+func TestMemoryStoreRestoreRejectsInvalidBucket(t *testing.T) {
+	s := NewMemoryStore()
+	err := s.Restore(strings.NewReader(`{"version":1,"buckets":[{"key":"a","capacity":0,"token_interval_ns":1}]}`))
+	if err == nil {
+		t.Fatal("expected error for invalid bucket")
+	}
+}
+
+func TestManagerSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiter.snapshot")
+
+	m1, err := NewManager(2, 1, time.Hour, time.Hour, time.Minute, WithSnapshotFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error creating manager: %v", err)
+	}
+	m1.Allow("user")
+	m1.Allow("user")
+	m1.Close()
+
+	m2, err := NewManager(2, 1, time.Hour, time.Hour, time.Minute, WithSnapshotFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error restoring manager: %v", err)
+	}
+	defer m2.Close()
+
+	if m2.Allow("user") {
+		t.Fatal("expected bucket state to survive restart")
+	}
+	if !m2.Allow("other") {
+		t.Fatal("expected unrelated key to be allowed")
+	}
+}
+
+func TestManagerSnapshotFileRequiresSnapshotter(t *testing.T) {
+	store, err := NewRedisStore(newFakeRedisEvalClient(), RedisStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = NewManagerWithStore(store, 2, 1, time.Second, time.Minute, time.Second,
+		WithSnapshotFile(filepath.Join(t.TempDir(), "snap")))
+	if err == nil {
+		t.Fatal("expected error for store without snapshot support")
+	}
+}
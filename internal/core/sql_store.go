@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const defaultSQLStoreTable = "ratelimiter_buckets"
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLDialect selects the SQL flavour SQLStore generates.
+type SQLDialect int
+
+const (
+	SQLDialectPostgres SQLDialect = iota
+	SQLDialectMySQL
+	SQLDialectSQLite
+)
+
+type SQLStoreOptions struct {
+	Dialect SQLDialect
+	// TableName defaults to ratelimiter_buckets. It must be a plain SQL
+	// identifier.
+	TableName string
+	// Now overrides the clock used for refills and last-seen tracking.
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+// SQLStore keeps buckets in a relational database through database/sql.
+// Each Allow runs one transaction that locks the key's row, applies the same
+// refill arithmetic as the Redis Lua script and writes the result back, so
+// instances sharing a database enforce a common limit.
+//
+// For SQLite, configure a busy timeout (e.g. _pragma=busy_timeout(5000)) or
+// limit the pool to one connection, since concurrent writers are rejected
+// with SQLITE_BUSY otherwise.
+type SQLStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	now     func() time.Time
+	q       sqlQueries
+}
+
+type sqlQueries struct {
+	schema     []string
+	insert     string
+	selectRow  string
+	update     string
+	deleteIdle string
+}
+
+func NewSQLStore(db *sql.DB, opts SQLStoreOptions) (*SQLStore, error) {
+	if db == nil {
+		return nil, errors.New("db cannot be nil")
+	}
+	table := opts.TableName
+	if table == "" {
+		table = defaultSQLStoreTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	q, err := buildSQLQueries(opts.Dialect, table)
+	if err != nil {
+		return nil, err
+	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	return &SQLStore{
+		db:      db,
+		dialect: opts.Dialect,
+		now:     now,
+		q:       q,
+	}, nil
+}
+
+func buildSQLQueries(dialect SQLDialect, table string) (sqlQueries, error) {
+	var q sqlQueries
+	switch dialect {
+	case SQLDialectPostgres:
+		q.schema = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				bucket_key TEXT PRIMARY KEY,
+				tokens BIGINT NOT NULL,
+				last_refill_ms BIGINT NOT NULL,
+				last_seen_ms BIGINT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS ` + table + `_last_seen_idx ON ` + table + ` (last_seen_ms)`,
+		}
+		q.insert = `INSERT INTO ` + table + ` (bucket_key, tokens, last_refill_ms, last_seen_ms) VALUES ($1, $2, $3, $3) ON CONFLICT (bucket_key) DO NOTHING`
+		q.selectRow = `SELECT tokens, last_refill_ms FROM ` + table + ` WHERE bucket_key = $1 FOR UPDATE`
+		q.update = `UPDATE ` + table + ` SET tokens = $1, last_refill_ms = $2, last_seen_ms = $3 WHERE bucket_key = $4`
+		q.deleteIdle = `DELETE FROM ` + table + ` WHERE last_seen_ms < $1`
+	case SQLDialectMySQL:
+		q.schema = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				bucket_key VARCHAR(255) NOT NULL PRIMARY KEY,
+				tokens BIGINT NOT NULL,
+				last_refill_ms BIGINT NOT NULL,
+				last_seen_ms BIGINT NOT NULL,
+				INDEX ` + table + `_last_seen_idx (last_seen_ms)
+			)`,
+		}
+		q.insert = `INSERT IGNORE INTO ` + table + ` (bucket_key, tokens, last_refill_ms, last_seen_ms) VALUES (?, ?, ?, ?)`
+		q.selectRow = `SELECT tokens, last_refill_ms FROM ` + table + ` WHERE bucket_key = ? FOR UPDATE`
+		q.update = `UPDATE ` + table + ` SET tokens = ?, last_refill_ms = ?, last_seen_ms = ? WHERE bucket_key = ?`
+		q.deleteIdle = `DELETE FROM ` + table + ` WHERE last_seen_ms < ?`
+	case SQLDialectSQLite:
+		q.schema = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+				bucket_key TEXT PRIMARY KEY,
+				tokens INTEGER NOT NULL,
+				last_refill_ms INTEGER NOT NULL,
+				last_seen_ms INTEGER NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS ` + table + `_last_seen_idx ON ` + table + ` (last_seen_ms)`,
+		}
+		// SQLite has no row locks: the insert runs first so the transaction
+		// takes the database write lock before reading.
+		q.insert = `INSERT INTO ` + table + ` (bucket_key, tokens, last_refill_ms, last_seen_ms) VALUES (?, ?, ?, ?) ON CONFLICT (bucket_key) DO NOTHING`
+		q.selectRow = `SELECT tokens, last_refill_ms FROM ` + table + ` WHERE bucket_key = ?`
+		q.update = `UPDATE ` + table + ` SET tokens = ?, last_refill_ms = ?, last_seen_ms = ? WHERE bucket_key = ?`
+		q.deleteIdle = `DELETE FROM ` + table + ` WHERE last_seen_ms < ?`
+	default:
+		return q, fmt.Errorf("unknown SQL dialect %d", dialect)
+	}
+	return q, nil
+}
+
+// Migrate creates the bucket table and its last-seen index if they do not
+// exist yet.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	for _, stmt := range s.q.schema {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Allow(key string, cfg BucketConfig) (Decision, error) {
+	if key == "" {
+		return Decision{}, errors.New("key cannot be empty")
+	}
+	if err := validateTokenBucketConfig(cfg.Capacity, cfg.RefillRate, cfg.Interval); err != nil {
+		return Decision{}, err
+	}
+	intervalMs := cfg.Interval.Milliseconds()
+	if intervalMs <= 0 {
+		return Decision{}, errors.New("interval must be at least 1ms")
+	}
+
+	ctx := context.Background()
+	nowMs := s.now().UnixMilli()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer tx.Rollback()
+
+	insertArgs := []any{key, cfg.Capacity, nowMs}
+	if s.dialect != SQLDialectPostgres {
+		insertArgs = append(insertArgs, nowMs)
+	}
+	if _, err := tx.ExecContext(ctx, s.q.insert, insertArgs...); err != nil {
+		return Decision{}, err
+	}
+
+	var tokens, lastRefillMs int64
+	if err := tx.QueryRowContext(ctx, s.q.selectRow, key).Scan(&tokens, &lastRefillMs); err != nil {
+		return Decision{}, err
+	}
+
+	tokens, lastRefillMs = refillMillis(tokens, lastRefillMs, nowMs, cfg.Capacity, cfg.RefillRate, intervalMs)
+	allowed := tokens > 0
+	if allowed {
+		tokens--
+	}
+
+	if _, err := tx.ExecContext(ctx, s.q.update, tokens, lastRefillMs, nowMs, key); err != nil {
+		return Decision{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Decision{}, err
+	}
+
+	return Decision{
+		Allowed:    allowed,
+		Remaining:  tokens,
+		Limit:      cfg.Capacity,
+		RetryAfter: retryAfterForConfig(cfg, allowed),
+	}, nil
+}
+
+// refillMillis mirrors the refill step of the Redis Lua script.
+func refillMillis(tokens, lastRefillMs, nowMs, capacity, refillRate, intervalMs int64) (int64, int64) {
+	tokens = min(capacity, tokens)
+	elapsed := nowMs - lastRefillMs
+	if elapsed <= 0 {
+		return tokens, lastRefillMs
+	}
+	newTokens := elapsed * refillRate / intervalMs
+	if newTokens <= 0 {
+		return tokens, lastRefillMs
+	}
+	tokens = min(capacity, tokens+newTokens)
+	return tokens, lastRefillMs + newTokens*intervalMs/refillRate
+}
+
+// DeleteInactiveBuckets removes every row not used since cutoff in a single
+// statement.
+func (s *SQLStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	_, err := s.db.ExecContext(context.Background(), s.q.deleteIdle, cutoff.UnixMilli())
+	return err
+}
+
+// Close is a no-op: the caller owns the *sql.DB.
+func (s *SQLStore) Close() error {
+	return nil
+}
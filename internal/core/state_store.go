@@ -0,0 +1,66 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StateStore is implemented by stores that can read and change bucket state
+// outside of Allow, e.g. for support tooling.
+type StateStore interface {
+	// Peek reports what Allow would decide for key right now without
+	// consuming a token. Unknown keys report a full bucket.
+	Peek(key string, cfg BucketConfig) (Decision, error)
+	// Reset refills the bucket for key to capacity.
+	Reset(key string) error
+	// Delete removes all state for key.
+	Delete(key string) error
+	// Set gives the bucket for key tokens tokens, creating it from cfg if
+	// needed. tokens is clamped to [0, capacity].
+	Set(key string, cfg BucketConfig, tokens int64) error
+}
+
+func (m *Manager) stateStore() (StateStore, error) {
+	s, ok := m.store.(StateStore)
+	if !ok {
+		return nil, fmt.Errorf("store does not support bucket state operations: %w", errors.ErrUnsupported)
+	}
+	return s, nil
+}
+
+// Peek returns the current decision metadata for key without consuming a
+// token.
+func (m *Manager) Peek(key string) (Decision, error) {
+	s, err := m.stateStore()
+	if err != nil {
+		return Decision{}, err
+	}
+	return s.Peek(key, m.configFor(key))
+}
+
+// Reset refills the bucket for key to capacity.
+func (m *Manager) Reset(key string) error {
+	s, err := m.stateStore()
+	if err != nil {
+		return err
+	}
+	return s.Reset(key)
+}
+
+// Delete removes all state for key.
+func (m *Manager) Delete(key string) error {
+	s, err := m.stateStore()
+	if err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
+
+// Set pre-seeds the bucket for key with tokens tokens.
+func (m *Manager) Set(key string, tokens int64) error {
+	s, err := m.stateStore()
+	if err != nil {
+		return err
+	}
+	return s.Set(key, m.configFor(key), tokens)
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManagerStateOperations(t *testing.T) {
+	m, err := NewManager(3, 1, time.Hour, time.Minute, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error creating manager: %v", err)
+	}
+	defer m.Close()
+
+	m.Allow("customer")
+	m.Allow("customer")
+	if d, err := m.Peek("customer"); err != nil || d.Remaining != 1 {
+		t.Fatalf("expected 1 remaining, got %+v, %v", d, err)
+	}
+
+	if err := m.Set("customer", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Allow("customer") {
+		t.Fatal("expected seeded empty bucket to deny")
+	}
+
+	if err := m.Reset("customer"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d, _ := m.Peek("customer"); d.Remaining != 3 {
+		t.Fatalf("expected reset bucket to be full, got %+v", d)
+	}
+
+	if err := m.Delete("customer"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestManagerStateOperationsUnsupported(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir(), FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(store, 3, 1, time.Hour, time.Minute, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error creating manager: %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.Peek("customer"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if err := m.Reset("customer"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
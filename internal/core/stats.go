@@ -0,0 +1,228 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDenialWindow = 5 * time.Minute
+	denialSlots         = 10
+	// maxDenialKeysPerSlot bounds tracker memory when denied keys are spoofed.
+	maxDenialKeysPerSlot = 10000
+)
+
+// KeyInfo is a tracked key together with its current state.
+type KeyInfo struct {
+	Key      string   `json:"key"`
+	Decision Decision `json:"decision"`
+}
+
+type KeyPage struct {
+	Keys []KeyInfo `json:"keys"`
+	Next string    `json:"next,omitempty"`
+}
+
+type KeyDenials struct {
+	Key     string `json:"key"`
+	Denials int64  `json:"denials"`
+}
+
+// WithDenialWindow sets how far back TopDenied looks. Defaults to 5 minutes.
+func WithDenialWindow(window time.Duration) ManagerOption {
+	return func(m *Manager) {
+		if window > 0 {
+			m.denials = newDenialTracker(window)
+		}
+	}
+}
+
+// denialTracker counts denials per key over a sliding window made of fixed
+// slots, so old counts expire a slot at a time.
+type denialTracker struct {
+	mu       sync.Mutex
+	slotSize time.Duration
+	slots    [denialSlots]denialSlot
+}
+
+type denialSlot struct {
+	start  time.Time
+	counts map[string]int64
+}
+
+func newDenialTracker(window time.Duration) *denialTracker {
+	return &denialTracker{slotSize: max(window/denialSlots, time.Millisecond)}
+}
+
+func (t *denialTracker) record(key string, now time.Time) {
+	start := now.Truncate(t.slotSize)
+	idx := int(start.UnixNano()/int64(t.slotSize)) % denialSlots
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slot := &t.slots[idx]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.counts = make(map[string]int64)
+	}
+	if _, ok := slot.counts[key]; ok || len(slot.counts) < maxDenialKeysPerSlot {
+		slot.counts[key]++
+	}
+}
+
+func (t *denialTracker) top(n int, now time.Time) []KeyDenials {
+	oldest := now.Truncate(t.slotSize).Add(-t.slotSize * (denialSlots - 1))
+	totals := make(map[string]int64)
+
+	t.mu.Lock()
+	for i := range t.slots {
+		slot := &t.slots[i]
+		if slot.start.Before(oldest) {
+			continue
+		}
+		for key, c := range slot.counts {
+			totals[key] += c
+		}
+	}
+	t.mu.Unlock()
+
+	out := make([]KeyDenials, 0, len(totals))
+	for key, c := range totals {
+		out = append(out, KeyDenials{Key: key, Denials: c})
+	}
+	slices.SortFunc(out, func(a, b KeyDenials) int {
+		if c := cmp.Compare(b.Denials, a.Denials); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func (m *Manager) keyLister() (KeyLister, error) {
+	l, ok := m.store.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("store does not support key enumeration: %w", errors.ErrUnsupported)
+	}
+	if m.keyPrefix != "" {
+		return prefixKeyLister{KeyLister: l, prefix: m.keyPrefix}, nil
+	}
+	return l, nil
+}
+
+// KeyCount returns the number of keys tracked by the store.
+func (m *Manager) KeyCount() (int, error) {
+	l, err := m.keyLister()
+	if err != nil {
+		return 0, err
+	}
+	return l.CountKeys()
+}
+
+// Keys returns one page of tracked keys. When the store implements
+// StateStore each key comes with its current state, read without consuming
+// a token.
+func (m *Manager) Keys(cursor string, limit int) (KeyPage, error) {
+	l, err := m.keyLister()
+	if err != nil {
+		return KeyPage{}, err
+	}
+	keys, next, err := l.ListKeys(cursor, limit)
+	if err != nil {
+		return KeyPage{}, err
+	}
+
+	page := KeyPage{Keys: make([]KeyInfo, 0, len(keys)), Next: next}
+	state, _ := m.store.(StateStore)
+	for _, key := range keys {
+		info := KeyInfo{Key: key}
+		if state != nil {
+			if info.Decision, err = state.Peek(key, m.configFor(key)); err != nil {
+				return KeyPage{}, err
+			}
+		}
+		page.Keys = append(page.Keys, info)
+	}
+	return page, nil
+}
+
+// ThrottledKeys returns up to limit keys whose bucket is currently empty, or
+// all of them if limit <= 0. It walks every tracked key, so it is meant for
+// dashboards, not request paths.
+func (m *Manager) ThrottledKeys(limit int) ([]KeyInfo, error) {
+	if _, err := m.stateStore(); err != nil {
+		return nil, err
+	}
+
+	var out []KeyInfo
+	cursor := ""
+	for {
+		page, err := m.Keys(cursor, 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range page.Keys {
+			if !info.Decision.Allowed {
+				out = append(out, info)
+				if len(out) == limit {
+					return out, nil
+				}
+			}
+		}
+		if page.Next == "" {
+			return out, nil
+		}
+		cursor = page.Next
+	}
+}
+
+// countKeys caches the number of tracked keys, and of those whose bucket is
+// empty, for the tracked keys gauge and the admin /stats endpoint, so neither
+// walks the key space when it is read. Counts a store cannot provide, or
+// that fail, keep their previous value.
+func (m *Manager) countKeys() {
+	if _, err := m.stateStore(); err != nil {
+		if n, err := m.KeyCount(); err == nil {
+			m.trackedKeys.Store(int64(n))
+			m.keysCountedAt.Store(time.Now().UnixNano())
+		}
+		return
+	}
+
+	var keys, throttled int64
+	cursor := ""
+	for {
+		page, err := m.Keys(cursor, 500)
+		if err != nil {
+			return
+		}
+		for _, info := range page.Keys {
+			keys++
+			if !info.Decision.Allowed {
+				throttled++
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	m.trackedKeys.Store(keys)
+	m.throttledKeys.Store(throttled)
+	m.keysCountedAt.Store(time.Now().UnixNano())
+}
+
+// TopDenied returns the n keys denied most often within the denial window
+// (see WithDenialWindow), or all denied keys if n <= 0. Counts are local to
+// this Manager.
+func (m *Manager) TopDenied(n int) []KeyDenials {
+	return m.denials.top(n, time.Now())
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func collectKeys(t *testing.T, l KeyLister, pageSize int) []string {
+	t.Helper()
+	var all []string
+	cursor := ""
+	for {
+		keys, next, err := l.ListKeys(cursor, pageSize)
+		if err != nil {
+			t.Fatalf("unexpected error listing keys: %v", err)
+		}
+		all = append(all, keys...)
+		if next == "" {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func TestMemoryStoreListKeysPaginates(t *testing.T) {
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{Shards: 4})
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+	for i := range 95 {
+		_, _ = s.Allow(fmt.Sprintf("key-%d", i), cfg)
+	}
+
+	keys := collectKeys(t, s, 10)
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k] {
+			t.Fatalf("key %s listed twice", k)
+		}
+		seen[k] = true
+	}
+	if len(seen) != 95 {
+		t.Fatalf("expected 95 keys, got %d", len(seen))
+	}
+	if n, _ := s.CountKeys(); n != 95 {
+		t.Fatalf("expected count 95, got %d", n)
+	}
+	if _, _, err := s.ListKeys("bogus", 10); err == nil {
+		t.Fatal("expected error for invalid cursor")
+	}
+}
+
+func TestRedisStoreListKeysWithScan(t *testing.T) {
+	client := newFakeRedisEvalClient()
+	s, _ := NewRedisStore(client, RedisStoreOptions{KeyPrefix: "rl:"})
+	cfg := BucketConfig{Capacity: 1, RefillRate: 1, Interval: time.Hour}
+	for i := range 25 {
+		_, _ = s.Allow(fmt.Sprintf("key-%d", i), cfg)
+	}
+	client.data["other:key"] = fakeRedisEntry{}
+
+	keys := collectKeys(t, s, 7)
+	if len(keys) != 25 {
+		t.Fatalf("expected 25 keys, got %d", len(keys))
+	}
+	for _, k := range keys {
+		if k[:4] != "key-" {
+			t.Fatalf("expected prefix to be stripped, got %q", k)
+		}
+	}
+	if n, err := s.CountKeys(); err != nil || n != 25 {
+		t.Fatalf("expected count 25, got %d, %v", n, err)
+	}
+}
+
+func TestRedisStoreListKeysWithoutScan(t *testing.T) {
+	s, _ := NewRedisStore(FailingRedisEvalClient{}, RedisStoreOptions{})
+	if _, _, err := s.ListKeys("", 10); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestManagerThrottledKeysAndTopDenied(t *testing.T) {
+	m, err := NewManager(2, 1, time.Hour, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error creating manager: %v", err)
+	}
+	defer m.Close()
+
+	for range 5 {
+		m.Allow("noisy")
+	}
+	for range 3 {
+		m.Allow("busy")
+	}
+	m.Allow("quiet")
+
+	if n, _ := m.KeyCount(); n != 3 {
+		t.Fatalf("expected 3 keys, got %d", n)
+	}
+
+	throttled, err := m.ThrottledKeys(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(throttled) != 2 {
+		t.Fatalf("expected 2 throttled keys, got %+v", throttled)
+	}
+
+	top := m.TopDenied(1)
+	if len(top) != 1 || top[0].Key != "noisy" || top[0].Denials != 3 {
+		t.Fatalf("expected noisy with 3 denials on top, got %+v", top)
+	}
+	if all := m.TopDenied(10); len(all) != 2 {
+		t.Fatalf("expected 2 denied keys, got %+v", all)
+	}
+	for _, n := range []int{0, -1} {
+		if all := m.TopDenied(n); len(all) != 2 {
+			t.Fatalf("expected n=%d to return all denied keys, got %+v", n, all)
+		}
+	}
+
+	page, err := m.Keys("", 10)
+	if err != nil || len(page.Keys) != 3 || page.Next != "" {
+		t.Fatalf("expected a single page of 3 keys, got %+v, %v", page, err)
+	}
+}
+
+func TestDenialTrackerExpiresOldSlots(t *testing.T) {
+	tr := newDenialTracker(time.Minute)
+	now := time.Unix(1000, 0)
+	tr.record("old", now)
+	tr.record("new", now.Add(55*time.Second))
+
+	if top := tr.top(10, now.Add(55*time.Second)); len(top) != 2 {
+		t.Fatalf("expected both keys within the window, got %+v", top)
+	}
+	if top := tr.top(10, now.Add(70*time.Second)); len(top) != 1 || top[0].Key != "new" {
+		t.Fatalf("expected only the recent key after the window moved, got %+v", top)
+	}
+}
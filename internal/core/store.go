@@ -1,6 +1,9 @@
 package core
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type BucketConfig struct {
 	Capacity   int64
@@ -13,10 +16,47 @@ type Decision struct {
 	Remaining  int64
 	Limit      int64
 	RetryAfter time.Duration
+	// DryRun is set when the decision was computed but not enforced.
+	// Allowed is then always true and WouldDeny reports whether the request
+	// would have been denied.
+	DryRun    bool
+	WouldDeny bool
+	// Access is set when the key is on an allow or deny list. Listed keys
+	// skip their bucket, so Remaining, Limit and RetryAfter are zero.
+	Access AccessList
+	// BannedUntil is set while the key is banned by WithBans. Banned keys
+	// are denied until then, with RetryAfter covering the rest of the ban.
+	BannedUntil time.Time
 }
 
+// Store keeps the token buckets of a Manager. Allow consumes a token from
+// the bucket for key, creating it full from cfg if needed. When cfg differs
+// from the config the bucket was last used with (e.g. after SetOverride),
+// the bucket keeps its tokens, capped at the new capacity, and refills at
+// the new rate; it does not start over full.
 type Store interface {
 	Allow(key string, cfg BucketConfig) (Decision, error)
 	DeleteInactiveBuckets(cutoff time.Time) error
 	Close() error
 }
+
+// guardedStore is implemented by stores that look up a key's access list
+// and ban, and count its denials towards a ban, in the same call that
+// takes its token, so a decision costs the store a single round trip.
+type guardedStore interface {
+	// allowGuarded returns listedDecision for listed keys, bannedDecision
+	// for keys banned under g.bans, and takes a token from the key's bucket
+	// otherwise. A ban caused by counting the denial is returned.
+	allowGuarded(ctx context.Context, key string, cfg BucketConfig, g allowGuard) (Decision, Ban, error)
+	// checksAccessLists reports whether decisions need to look keys up in
+	// the access lists, i.e. whether any key can be listed.
+	checksAccessLists() bool
+}
+
+// allowGuard is what a guarded decision checks. lists is set to look the
+// key up in the access lists; bans is nil without WithBans.
+type allowGuard struct {
+	lists         bool
+	bans          *BanPolicy
+	recordDenials bool
+}
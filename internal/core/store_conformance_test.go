@@ -0,0 +1,123 @@
+package core_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carr-o-t/ratelimiter"
+	"github.com/carr-o-t/ratelimiter/internal/core"
+	"github.com/carr-o-t/ratelimiter/storetest"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		return core.NewMemoryStoreWithOptions(core.MemoryStoreOptions{Now: now})
+	}, storetest.Options{})
+}
+
+func TestBoundedMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		return core.NewMemoryStoreWithOptions(core.MemoryStoreOptions{Now: now, MaxKeys: 1024})
+	}, storetest.Options{})
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		s, err := core.OpenFileStore(t.TempDir(), core.FileStoreOptions{Now: now, SyncPolicy: core.SyncNever})
+		if err != nil {
+			t.Fatalf("failed to open file store: %v", err)
+		}
+		return s
+	}, storetest.Options{})
+}
+
+func TestPeerStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		// Two peers sharing a clock; the first one is tested, so about half
+		// of the keys are forwarded to the second.
+		var stores [2]*core.PeerStore
+		var urls []string
+		for i := range stores {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				stores[i].Handler().ServeHTTP(w, r)
+			}))
+			t.Cleanup(srv.Close)
+			urls = append(urls, srv.URL)
+		}
+		for i := range stores {
+			s, err := core.NewPeerStore(core.PeerStoreOptions{
+				Self:  urls[i],
+				Peers: urls,
+				// A generous timeout keeps slow (e.g. -race) runs from
+				// falling back locally and double-counting keys.
+				Client: &http.Client{Timeout: 10 * time.Second},
+				Memory: core.MemoryStoreOptions{Now: now},
+			})
+			if err != nil {
+				t.Fatalf("failed to create peer store: %v", err)
+			}
+			stores[i] = s
+		}
+		return &peerClusterStore{PeerStore: stores[0], other: stores[1]}
+	}, storetest.Options{})
+}
+
+func TestGossipStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		s, err := core.NewGossipStore(core.GossipStoreOptions{
+			Self:   "http://127.0.0.1:0",
+			Memory: core.MemoryStoreOptions{Now: now},
+		})
+		if err != nil {
+			t.Fatalf("failed to create gossip store: %v", err)
+		}
+		return s
+	}, storetest.Options{})
+}
+
+// peerClusterStore cleans up both peers of a test cluster.
+type peerClusterStore struct {
+	*core.PeerStore
+	other *core.PeerStore
+}
+
+func (s *peerClusterStore) DeleteInactiveBuckets(cutoff time.Time) error {
+	if err := s.other.DeleteInactiveBuckets(cutoff); err != nil {
+		return err
+	}
+	return s.PeerStore.DeleteInactiveBuckets(cutoff)
+}
+
+func newRedisStoreForConformance(t *testing.T, client core.RedisEvalClient, now func() time.Time) *core.RedisStore {
+	s, err := core.NewRedisStore(client, core.RedisStoreOptions{
+		KeyPrefix:   "conformance:",
+		KeyTTL:      time.Minute,
+		Now:         now,
+		AccessLists: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	return s
+}
+
+func TestRedisStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		return newRedisStoreForConformance(t, core.NewFakeRedisEvalClient(), now)
+	}, storetest.Options{
+		NewFailingStore: func(t *testing.T, now func() time.Time) ratelimiter.Store {
+			return newRedisStoreForConformance(t, core.FailingRedisEvalClient{}, now)
+		},
+	})
+}
+
+// TestRedisClusterStoreConformance runs every decision through the access
+// list and ban checks against a client that, like Redis Cluster, rejects
+// scripts whose keys are in different hash slots.
+func TestRedisClusterStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) ratelimiter.Store {
+		return core.GuardedRedisStore{RedisStore: newRedisStoreForConformance(t, core.NewFakeRedisClusterClient(), now)}
+	}, storetest.Options{})
+}
@@ -5,6 +5,26 @@ local interval_ms = tonumber(ARGV[3])
 local now_ms = tonumber(ARGV[4])
 local ttl_ms = tonumber(ARGV[5])
 
+-- Guarded calls pass the key's access list as KEYS[2] and its ban hash as
+-- KEYS[3]. ARGV[6] is 1 to check the list and ARGV[7] to check the ban;
+-- listed and banned keys skip their bucket.
+local list_key = KEYS[2]
+local ban_key = KEYS[3]
+if list_key and tonumber(ARGV[6]) == 1 then
+  local list = redis.call("GET", list_key)
+  if list then
+    return {0, 0, list}
+  end
+end
+
+if ban_key and tonumber(ARGV[7]) == 1 then
+  local ban = redis.call("HMGET", ban_key, "level", "banned_until_ms")
+  local banned_until_ms = tonumber(ban[2])
+  if banned_until_ms and banned_until_ms > now_ms then
+    return {0, 0, tonumber(ban[1]), banned_until_ms, 0}
+  end
+end
+
 local tokens = tonumber(redis.call("HGET", key, "tokens"))
 local last_refill_ms = tonumber(redis.call("HGET", key, "last_refill_ms"))
 
@@ -12,6 +32,7 @@ if not tokens or not last_refill_ms then
   tokens = capacity
   last_refill_ms = now_ms
 end
+tokens = math.min(capacity, tokens)
 
 local elapsed = now_ms - last_refill_ms
 if elapsed > 0 then
@@ -42,4 +63,18 @@ if ttl_ms > 0 then
   redis.call("PEXPIRE", key, ttl_ms)
 end
 
+-- ARGV[8] is 1 when denials count towards a ban, with the ban policy in
+-- ARGV[9] to ARGV[14].
+if allowed == 0 and ban_key and tonumber(ARGV[8]) == 1 then
+  local ban = record_denial(ban_key, now_ms,
+    tonumber(ARGV[9]),
+    tonumber(ARGV[10]),
+    tonumber(ARGV[11]),
+    tonumber(ARGV[12]),
+    tonumber(ARGV[13]),
+    tonumber(ARGV[14])
+  )
+  return {0, tokens, ban[1], ban[2], 1}
+end
+
 return {allowed, tokens}
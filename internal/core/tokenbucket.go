@@ -15,7 +15,8 @@ type TokenBucket struct {
 	lastRefill time.Time
 	lastSeen   time.Time
 
-	mu sync.Mutex
+	now func() time.Time
+	mu  sync.Mutex
 }
 
 func validateTokenBucketConfig(capacity int64, tokens int64, per time.Duration) error {
@@ -39,11 +40,15 @@ func NewTokenBucket(capacity int64, refillRate int64, per ...time.Duration) (*To
 	if len(per) > 0 {
 		interval = per[0]
 	}
+	return newTokenBucket(capacity, refillRate, interval, time.Now)
+}
+
+func newTokenBucket(capacity int64, refillRate int64, interval time.Duration, clock func() time.Time) (*TokenBucket, error) {
 	if err := validateTokenBucketConfig(capacity, refillRate, interval); err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
+	now := clock()
 	return &TokenBucket{
 		capacity:   capacity,
 		tokens:     capacity,
@@ -51,11 +56,11 @@ func NewTokenBucket(capacity int64, refillRate int64, per ...time.Duration) (*To
 		refillRate: refillRate,
 		lastRefill: now,
 		lastSeen:   now,
+		now:        clock,
 	}, nil
 }
 
-func (tb *TokenBucket) refill() {
-	now := time.Now()
+func (tb *TokenBucket) refill(now time.Time) {
 	elapsed := now.Sub(tb.lastRefill)
 
 	newTokens := int64(float64(elapsed) / float64(tb.interval) * float64(tb.refillRate))
@@ -77,9 +82,9 @@ func (tb *TokenBucket) allowDecision() Decision {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	now := time.Now()
+	now := tb.now()
 	tb.lastSeen = now
-	tb.refill()
+	tb.refill(now)
 
 	decision := Decision{
 		Limit:     tb.capacity,
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracer starts spans for rate limit decisions. It is small enough to be
+// adapted to OpenTelemetry (trace.Tracer plus trace.SpanFromContext) or to
+// the in-memory RecordingTracer used in tests.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, if any,
+	// and returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// SpanFromContext returns the span carried by ctx. It must return a
+	// usable Span, e.g. a no-op one, when ctx has none.
+	SpanFromContext(ctx context.Context) Span
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	AddEvent(name string, attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute is a span attribute. Value is a string, bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// ContextStore is implemented by stores whose Allow can use the caller's
+// context, e.g. for cancellation or tracing. Managers prefer it over Allow.
+type ContextStore interface {
+	AllowContext(ctx context.Context, key string, cfg BucketConfig) (Decision, error)
+}
+
+// WithTracer makes the Manager start a span for every decision and add a
+// span event to the request's span when Middleware denies a request.
+func WithTracer(t Tracer) ManagerOption {
+	return func(m *Manager) {
+		m.tracer = t
+	}
+}
+
+// keyHash identifies a key in traces without recording the key itself,
+// which is often an IP address or API key.
+func keyHash(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func decisionAttributes(d Decision) []Attribute {
+	return []Attribute{
+		{Key: "ratelimiter.allowed", Value: d.Allowed},
+		{Key: "ratelimiter.remaining", Value: d.Remaining},
+		{Key: "ratelimiter.limit", Value: d.Limit},
+	}
+}
+
+func latencyAttribute(key string, d time.Duration) Attribute {
+	return Attribute{Key: key, Value: float64(d) / float64(time.Millisecond)}
+}
+
+// RecordingTracer keeps finished spans in memory. It is meant for tests.
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// RecordedSpan is a span captured by RecordingTracer.
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan
+	Attributes map[string]any
+	Events     []RecordedEvent
+	Errors     []error
+	Ended      bool
+}
+
+type RecordedEvent struct {
+	Name       string
+	Attributes map[string]any
+}
+
+type recordingSpanKey struct{}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	span   *RecordedSpan
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordingSpan{
+		tracer: t,
+		span:   &RecordedSpan{Name: name, Attributes: make(map[string]any)},
+	}
+	if parent, ok := ctx.Value(recordingSpanKey{}).(*recordingSpan); ok {
+		s.span.Parent = parent.span
+	}
+	return context.WithValue(ctx, recordingSpanKey{}, s), s
+}
+
+func (t *RecordingTracer) SpanFromContext(ctx context.Context) Span {
+	if s, ok := ctx.Value(recordingSpanKey{}).(*recordingSpan); ok {
+		return s
+	}
+	return noopSpan{}
+}
+
+// Spans returns the spans ended so far, in the order they ended.
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]RecordedSpan, len(t.spans))
+	copy(out, t.spans)
+	return out
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...Attribute) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, a := range attrs {
+		s.span.Attributes[a.Key] = a.Value
+	}
+}
+
+func (s *recordingSpan) AddEvent(name string, attrs ...Attribute) {
+	e := RecordedEvent{Name: name, Attributes: make(map[string]any, len(attrs))}
+	for _, a := range attrs {
+		e.Attributes[a.Key] = a.Value
+	}
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.Events = append(s.span.Events, e)
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.Errors = append(s.span.Errors, err)
+}
+
+func (s *recordingSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	if s.span.Ended {
+		return
+	}
+	s.span.Ended = true
+	s.tracer.spans = append(s.tracer.spans, *s.span)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute)    {}
+func (noopSpan) AddEvent(string, ...Attribute) {}
+func (noopSpan) RecordError(error)             {}
+func (noopSpan) End()                          {}
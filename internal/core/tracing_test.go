@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func findSpan(spans []RecordedSpan, name string) (RecordedSpan, bool) {
+	for _, s := range spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return RecordedSpan{}, false
+}
+
+func TestManagerTracesDecisions(t *testing.T) {
+	tracer := NewRecordingTracer()
+	store, err := NewRedisStore(newFakeRedisEvalClient(), RedisStoreOptions{Tracer: tracer})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(store, 1, 1, time.Hour, time.Minute, time.Hour,
+		WithTracer(tracer), WithPolicyName("login"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	if _, err := m.AllowDecisionContext(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parent.End()
+
+	spans := tracer.Spans()
+	allow, ok := findSpan(spans, "ratelimiter.allow")
+	if !ok {
+		t.Fatalf("expected a manager span, got %+v", spans)
+	}
+	if allow.Parent == nil || allow.Parent.Name != "request" {
+		t.Fatalf("expected manager span to be a child of the request span, got %+v", allow.Parent)
+	}
+	if allow.Attributes["ratelimiter.policy"] != "login" ||
+		allow.Attributes["ratelimiter.allowed"] != true ||
+		allow.Attributes["ratelimiter.remaining"] != int64(0) ||
+		allow.Attributes["ratelimiter.key_hash"] != keyHash("10.0.0.1") {
+		t.Fatalf("unexpected manager span attributes %+v", allow.Attributes)
+	}
+	if _, ok := allow.Attributes["ratelimiter.store_latency_ms"].(float64); !ok {
+		t.Fatalf("expected store latency attribute, got %+v", allow.Attributes)
+	}
+	for _, v := range allow.Attributes {
+		if v == "10.0.0.1" {
+			t.Fatal("expected the raw key not to be recorded")
+		}
+	}
+
+	redis, ok := findSpan(spans, "ratelimiter.redis.allow")
+	if !ok || redis.Parent == nil || redis.Parent.Name != "ratelimiter.allow" {
+		t.Fatalf("expected a redis span under the manager span, got %+v", redis)
+	}
+}
+
+func TestManagerTraceRecordsStoreErrors(t *testing.T) {
+	tracer := NewRecordingTracer()
+	store, err := NewRedisStore(FailingRedisEvalClient{}, RedisStoreOptions{Tracer: tracer})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := NewManagerWithStore(store, 1, 1, time.Hour, time.Minute, time.Hour, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	m.Allow("a")
+
+	for _, s := range tracer.Spans() {
+		if len(s.Errors) != 1 {
+			t.Fatalf("expected span %s to record the store error, got %+v", s.Name, s.Errors)
+		}
+		if _, ok := s.Attributes["ratelimiter.allowed"]; ok {
+			t.Fatalf("expected no decision attributes on failed span %s", s.Name)
+		}
+	}
+}
+
+func TestMiddlewareAddsDenialEventToRequestSpan(t *testing.T) {
+	tracer := NewRecordingTracer()
+	m, err := NewManager(1, 1, time.Hour, time.Minute, time.Hour, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	handler := m.Middleware(func(*http.Request) string { return "k" })(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for range 2 {
+		ctx, span := tracer.Start(context.Background(), "request")
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		span.End()
+	}
+
+	var requests []RecordedSpan
+	for _, s := range tracer.Spans() {
+		if s.Name == "request" {
+			requests = append(requests, s)
+		}
+	}
+	if len(requests) != 2 || len(requests[0].Events) != 0 {
+		t.Fatalf("expected no event on the allowed request, got %+v", requests)
+	}
+	if ev := requests[1].Events; len(ev) != 1 || ev[0].Name != "ratelimiter.denied" || ev[0].Attributes["ratelimiter.retry_after_ms"].(int64) <= 0 {
+		t.Fatalf("expected a denial event on the denied request, got %+v", ev)
+	}
+}
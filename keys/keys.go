@@ -0,0 +1,262 @@
+// Package keys builds Middleware key functions from request attributes:
+// client IP, headers, query parameters, the authenticated principal, JWT
+// claims, the ServeMux route pattern and the HTTP method.
+//
+// Components can be combined with All, which needs every component, and
+// FirstOf, which falls back through a chain:
+//
+//	// verifyJWT checks the token with your JWT library and returns its claims.
+//	keyFunc := keys.KeyFunc(keys.All(
+//		keys.Route(),
+//		keys.FirstOf(keys.VerifiedJWTClaim("sub", verifyJWT), keys.APIKey("X-API-Key"), keys.RemoteIP()),
+//	), "anonymous")
+//	mw := m.Middleware(keyFunc)
+//
+// Keys are "name=value" pairs joined with "|", e.g.
+// "route=GET /api/{id}|jwt:sub=user-42", so values from different components
+// never collide.
+package keys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/carr-o-t/ratelimiter"
+)
+
+// Component extracts one part of a key from a request.
+type Component struct {
+	name    string
+	extract func(*http.Request) (string, bool)
+}
+
+// New creates a component named name. extract reports false when the
+// request does not have the attribute; empty values are treated as missing
+// too.
+func New(name string, extract func(*http.Request) (string, bool)) Component {
+	return Component{name: name, extract: extract}
+}
+
+// From creates a component from a Middleware key function, such as one
+// returned by ratelimiter.ClientIPKeyFunc.
+func From(name string, keyFunc func(*http.Request) string) Component {
+	return New(name, func(r *http.Request) (string, bool) {
+		return keyFunc(r), true
+	})
+}
+
+// Value returns the component's key part for r, or false if it is missing.
+func (c Component) Value(r *http.Request) (string, bool) {
+	v, ok := c.extract(r)
+	if !ok || v == "" {
+		return "", false
+	}
+	if c.name == "" {
+		return v, true
+	}
+	return c.name + "=" + valueEscaper.Replace(v), true
+}
+
+// valueEscaper escapes the separator between components so that values
+// cannot forge extra components.
+var valueEscaper = strings.NewReplacer("%", "%25", "|", "%7C")
+
+// KeyFunc turns c into a Middleware key function. Requests for which c is
+// missing get fallback as their key, so they share one bucket.
+func KeyFunc(c Component, fallback string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if v, ok := c.Value(r); ok {
+			return v
+		}
+		return fallback
+	}
+}
+
+// All joins components in order. It is missing if any component is.
+func All(components ...Component) Component {
+	return Component{extract: func(r *http.Request) (string, bool) {
+		parts := make([]string, 0, len(components))
+		for _, c := range components {
+			v, ok := c.Value(r)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, v)
+		}
+		return strings.Join(parts, "|"), len(parts) > 0
+	}}
+}
+
+// FirstOf uses the first component that is present.
+func FirstOf(components ...Component) Component {
+	return Component{extract: func(r *http.Request) (string, bool) {
+		for _, c := range components {
+			if v, ok := c.Value(r); ok {
+				return v, true
+			}
+		}
+		return "", false
+	}}
+}
+
+// RemoteIP is the connection's IP address without the port. Use From with
+// ratelimiter.ClientIPKeyFunc behind proxies.
+func RemoteIP() Component {
+	return From("ip", ratelimiter.RemoteIP)
+}
+
+func Header(name string) Component {
+	return New("header:"+http.CanonicalHeaderKey(name), func(r *http.Request) (string, bool) {
+		return r.Header.Get(name), true
+	})
+}
+
+func Query(name string) Component {
+	return New("query:"+name, func(r *http.Request) (string, bool) {
+		return r.URL.Query().Get(name), true
+	})
+}
+
+func Method() Component {
+	return New("method", func(r *http.Request) (string, bool) {
+		return r.Method, true
+	})
+}
+
+// Route is the http.ServeMux pattern that matched the request, e.g.
+// "GET /api/items/{id}", so all items share a bucket. It is missing when
+// the request was not routed by a ServeMux, including when the middleware
+// wraps the mux instead of the handlers registered on it.
+func Route() Component {
+	return New("route", func(r *http.Request) (string, bool) {
+		return r.Pattern, true
+	})
+}
+
+// APIKey is the value of header, hashed so that secrets are not stored as
+// bucket keys.
+func APIKey(header string) Component {
+	return New("apikey", func(r *http.Request) (string, bool) {
+		v := r.Header.Get(header)
+		if v == "" {
+			return "", false
+		}
+		return hashValue(v), true
+	})
+}
+
+// Principal is the authenticated principal stored in the request context
+// under ctxKey by an authentication middleware. The value must be a string
+// or a fmt.Stringer.
+func Principal(ctxKey any) Component {
+	return New("principal", func(r *http.Request) (string, bool) {
+		return contextString(r.Context(), ctxKey)
+	})
+}
+
+func contextString(ctx context.Context, key any) (string, bool) {
+	switch v := ctx.Value(key).(type) {
+	case string:
+		return v, true
+	case fmt.Stringer:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// JWTSubject is the unverified "sub" claim of the bearer token. See
+// JWTClaim.
+func JWTSubject() Component {
+	return JWTClaim("sub")
+}
+
+// JWTClaim is claim from the bearer token's payload, decoded without
+// checking the signature. Clients can put anything there, so only use it
+// when the token is verified before the limiter runs, or where a forged
+// claim merely moves the client to another bucket. Use VerifiedJWTClaim
+// otherwise. Its component is named "jwt-unverified:<claim>", so forged
+// claims never share a bucket with verified ones.
+func JWTClaim(claim string) Component {
+	return New("jwt-unverified:"+claim, func(r *http.Request) (string, bool) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return "", false
+		}
+		claims, err := decodeJWTPayload(token)
+		if err != nil {
+			return "", false
+		}
+		return claimString(claims[claim])
+	})
+}
+
+// VerifiedJWTClaim is claim from the bearer token after verify accepted
+// it. verify checks the signature and expiry with the caller's JWT library
+// and returns the token's claims. Rejected tokens make the component
+// missing. Its component is named "jwt:<claim>".
+func VerifiedJWTClaim(claim string, verify func(token string) (map[string]any, error)) Component {
+	return New("jwt:"+claim, func(r *http.Request) (string, bool) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return "", false
+		}
+		claims, err := verify(token)
+		if err != nil {
+			return "", false
+		}
+		return claimString(claims[claim])
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func decodeJWTPayload(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	var claims map[string]any
+	if err := dec.Decode(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func claimString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func hashValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,146 @@
+package keys
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRequest(method, target string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = "192.0.2.1:5555"
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func jwt(payload string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestComponents(t *testing.T) {
+	type principalKey struct{}
+	r := newRequest(http.MethodPost, "/items?tenant=acme", map[string]string{
+		"X-Api-Key":     "secret",
+		"X-Region":      "eu|west",
+		"Authorization": "Bearer " + jwt(`{"sub":"user-42","org":7}`),
+	})
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, "alice"))
+
+	cases := []struct {
+		name string
+		c    Component
+		want string
+	}{
+		{"remote ip", RemoteIP(), "ip=192.0.2.1"},
+		{"header", Header("x-region"), "header:X-Region=eu%7Cwest"},
+		{"query", Query("tenant"), "query:tenant=acme"},
+		{"method", Method(), "method=POST"},
+		{"api key is hashed", APIKey("X-Api-Key"), "apikey=" + hashValue("secret")},
+		{"principal", Principal(principalKey{}), "principal=alice"},
+		{"jwt subject", JWTSubject(), "jwt-unverified:sub=user-42"},
+		{"numeric claim", JWTClaim("org"), "jwt-unverified:org=7"},
+	}
+	for _, c := range cases {
+		if got, ok := c.c.Value(r); !ok || got != c.want {
+			t.Fatalf("%s: got %q (%v), want %q", c.name, got, ok, c.want)
+		}
+	}
+
+	for name, c := range map[string]Component{
+		"header":    Header("X-Missing"),
+		"query":     Query("missing"),
+		"principal": Principal("other"),
+		"claim":     JWTClaim("missing"),
+	} {
+		if _, ok := c.Value(r); ok {
+			t.Fatalf("%s: expected component to be missing", name)
+		}
+	}
+}
+
+func TestJWTClaimRejectsMalformedTokens(t *testing.T) {
+	for _, auth := range []string{"", "Basic abc", "Bearer not-a-jwt", "Bearer a.!!!.c", "Bearer " + jwt(`[1]`)} {
+		r := newRequest(http.MethodGet, "/", map[string]string{"Authorization": auth})
+		if _, ok := JWTSubject().Value(r); ok {
+			t.Fatalf("expected no subject for Authorization %q", auth)
+		}
+	}
+}
+
+func TestVerifiedJWTClaim(t *testing.T) {
+	token := jwt(`{"sub":"forged"}`)
+	verify := func(tok string) (map[string]any, error) {
+		if tok != token {
+			return nil, errors.New("bad signature")
+		}
+		return map[string]any{"sub": "verified"}, nil
+	}
+	c := VerifiedJWTClaim("sub", verify)
+
+	r := newRequest(http.MethodGet, "/", map[string]string{"Authorization": "Bearer " + token})
+	if got, ok := c.Value(r); !ok || got != "jwt:sub=verified" {
+		t.Fatalf("expected verified claims to be used, got %q", got)
+	}
+	forged := newRequest(http.MethodGet, "/", map[string]string{"Authorization": "Bearer " + jwt(`{"sub":"verified"}`)})
+	if got, _ := JWTSubject().Value(forged); got == "jwt:sub=verified" {
+		t.Fatalf("expected unverified claims not to share keys with verified ones, got %q", got)
+	}
+	r = newRequest(http.MethodGet, "/", map[string]string{"Authorization": "Bearer " + jwt(`{"sub":"x"}`)})
+	if _, ok := c.Value(r); ok {
+		t.Fatal("expected rejected token to be missing")
+	}
+}
+
+func TestRouteUsesServeMuxPattern(t *testing.T) {
+	var got string
+	keyFunc := KeyFunc(All(Route(), Method()), "unrouted")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(_ http.ResponseWriter, r *http.Request) {
+		got = keyFunc(r)
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/items/17", nil))
+
+	if got != "route=GET /items/{id}|method=GET" {
+		t.Fatalf("unexpected key %q", got)
+	}
+	if k := keyFunc(newRequest(http.MethodGet, "/items/17", nil)); k != "unrouted" {
+		t.Fatalf("expected fallback outside a mux, got %q", k)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	keyFunc := KeyFunc(FirstOf(JWTSubject(), APIKey("X-Api-Key"), RemoteIP()), "anonymous")
+
+	withToken := newRequest(http.MethodGet, "/", map[string]string{"Authorization": "Bearer " + jwt(`{"sub":"u1"}`), "X-Api-Key": "k"})
+	if got := keyFunc(withToken); got != "jwt-unverified:sub=u1" {
+		t.Fatalf("expected subject first, got %q", got)
+	}
+	withKey := newRequest(http.MethodGet, "/", map[string]string{"X-Api-Key": "k"})
+	if got := keyFunc(withKey); got != "apikey="+hashValue("k") {
+		t.Fatalf("expected API key second, got %q", got)
+	}
+	if got := keyFunc(newRequest(http.MethodGet, "/", nil)); got != "ip=192.0.2.1" {
+		t.Fatalf("expected IP last, got %q", got)
+	}
+
+	empty := KeyFunc(FirstOf(Header("X-Missing")), "anonymous")
+	if got := empty(newRequest(http.MethodGet, "/", nil)); got != "anonymous" {
+		t.Fatalf("expected fallback key, got %q", got)
+	}
+}
+
+func TestAllRequiresEveryComponent(t *testing.T) {
+	c := All(Method(), Header("X-Tenant"))
+	if _, ok := c.Value(newRequest(http.MethodGet, "/", nil)); ok {
+		t.Fatal("expected All to be missing when a component is")
+	}
+	if got, ok := c.Value(newRequest(http.MethodGet, "/", map[string]string{"X-Tenant": "t"})); !ok || got != "method=GET|header:X-Tenant=t" {
+		t.Fatalf("unexpected composite key %q", got)
+	}
+}
@@ -0,0 +1,458 @@
+// Package storetest provides a conformance suite for ratelimiter.Store
+// implementations. Backends call Run from their own tests to check they
+// behave the same way as the built-in memory and Redis stores.
+package storetest
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carr-o-t/ratelimiter"
+)
+
+// idleTimeout is how long a key must be unused before the suite expects
+// cleanup to drop it. Stores that expire keys on their own must be configured
+// with a TTL below twice this value.
+const idleTimeout = time.Hour
+
+// Factory creates a fresh, empty store whose notion of the current time is
+// taken from now.
+type Factory func(t *testing.T, now func() time.Time) ratelimiter.Store
+
+// Options configures the optional parts of the suite.
+type Options struct {
+	// NewFailingStore, if set, creates a store whose backend rejects every
+	// operation. It is used to check that backend errors are returned to the
+	// caller instead of being turned into decisions. When nil, the error
+	// propagation case is skipped.
+	NewFailingStore Factory
+}
+
+// Clock is a manually driven clock, safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock reading start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock by d, which may be negative to simulate skew.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to now, which may be earlier than the current time.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Run executes the full conformance suite against stores built by newStore.
+func Run(t *testing.T, newStore Factory, opts Options) {
+	t.Run("Burst", func(t *testing.T) { testBurst(t, newStore) })
+	t.Run("Refill", func(t *testing.T) { testRefill(t, newStore) })
+	t.Run("RefillCappedAtCapacity", func(t *testing.T) { testRefillCapped(t, newStore) })
+	t.Run("ConcurrentSameKey", func(t *testing.T) { testConcurrentSameKey(t, newStore) })
+	t.Run("ConcurrentMultipleKeys", func(t *testing.T) { testConcurrentMultipleKeys(t, newStore) })
+	t.Run("KeyIsolation", func(t *testing.T) { testKeyIsolation(t, newStore) })
+	t.Run("KeyLayout", func(t *testing.T) { testKeyLayout(t, newStore) })
+	t.Run("Cleanup", func(t *testing.T) { testCleanup(t, newStore) })
+	t.Run("ConfigChange", func(t *testing.T) { testConfigChange(t, newStore) })
+	t.Run("InvalidConfig", func(t *testing.T) { testInvalidConfig(t, newStore) })
+	t.Run("BackendError", func(t *testing.T) {
+		if opts.NewFailingStore == nil {
+			t.Skip("no failing store factory configured")
+		}
+		testBackendError(t, opts.NewFailingStore)
+	})
+	t.Run("ClockSkew", func(t *testing.T) { testClockSkew(t, newStore) })
+	t.Run("State", func(t *testing.T) { testState(t, newStore) })
+}
+
+func newClock() *Clock {
+	return NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func open(t *testing.T, newStore Factory, clock *Clock) ratelimiter.Store {
+	t.Helper()
+	s := newStore(t, clock.Now)
+	if s == nil {
+		t.Fatal("factory returned nil store")
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("close: %v", err)
+		}
+	})
+	return s
+}
+
+// slowConfig returns a config that does not refill within any test window.
+func slowConfig(capacity int64) ratelimiter.BucketConfig {
+	return ratelimiter.BucketConfig{Capacity: capacity, RefillRate: 1, Interval: 1000 * time.Hour}
+}
+
+func allow(t *testing.T, s ratelimiter.Store, key string, cfg ratelimiter.BucketConfig) ratelimiter.Decision {
+	t.Helper()
+	d, err := s.Allow(key, cfg)
+	if err != nil {
+		t.Fatalf("Allow(%q): unexpected error: %v", key, err)
+	}
+	if d.Remaining < 0 {
+		t.Fatalf("Allow(%q): negative remaining %d", key, d.Remaining)
+	}
+	if d.RetryAfter < 0 {
+		t.Fatalf("Allow(%q): negative retry-after %v", key, d.RetryAfter)
+	}
+	return d
+}
+
+func mustAllow(t *testing.T, s ratelimiter.Store, key string, cfg ratelimiter.BucketConfig) ratelimiter.Decision {
+	t.Helper()
+	d := allow(t, s, key, cfg)
+	if !d.Allowed {
+		t.Fatalf("Allow(%q): expected request to pass, got %+v", key, d)
+	}
+	return d
+}
+
+func mustDeny(t *testing.T, s ratelimiter.Store, key string, cfg ratelimiter.BucketConfig) ratelimiter.Decision {
+	t.Helper()
+	d := allow(t, s, key, cfg)
+	if d.Allowed {
+		t.Fatalf("Allow(%q): expected request to be blocked, got %+v", key, d)
+	}
+	return d
+}
+
+func testBurst(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	cfg := slowConfig(5)
+
+	for i := int64(1); i <= cfg.Capacity; i++ {
+		d := mustAllow(t, s, "burst", cfg)
+		if d.Limit != cfg.Capacity {
+			t.Fatalf("request %d: expected limit %d, got %d", i, cfg.Capacity, d.Limit)
+		}
+		if d.Remaining != cfg.Capacity-i {
+			t.Fatalf("request %d: expected remaining %d, got %d", i, cfg.Capacity-i, d.Remaining)
+		}
+		if d.RetryAfter != 0 {
+			t.Fatalf("request %d: expected no retry-after on allowed request, got %v", i, d.RetryAfter)
+		}
+	}
+
+	d := mustDeny(t, s, "burst", cfg)
+	if d.Remaining != 0 {
+		t.Fatalf("expected remaining 0 when blocked, got %d", d.Remaining)
+	}
+	if d.Limit != cfg.Capacity {
+		t.Fatalf("expected limit %d when blocked, got %d", cfg.Capacity, d.Limit)
+	}
+	if d.RetryAfter <= 0 || d.RetryAfter > cfg.Interval {
+		t.Fatalf("expected retry-after in (0, %v], got %v", cfg.Interval, d.RetryAfter)
+	}
+}
+
+func testRefill(t *testing.T, newStore Factory) {
+	clock := newClock()
+	s := open(t, newStore, clock)
+	cfg := ratelimiter.BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Second}
+
+	mustAllow(t, s, "refill", cfg)
+	mustAllow(t, s, "refill", cfg)
+	mustDeny(t, s, "refill", cfg)
+
+	clock.Advance(999 * time.Millisecond)
+	mustDeny(t, s, "refill", cfg)
+
+	clock.Advance(time.Millisecond)
+	mustAllow(t, s, "refill", cfg)
+	mustDeny(t, s, "refill", cfg)
+
+	clock.Advance(2 * time.Second)
+	mustAllow(t, s, "refill", cfg)
+	mustAllow(t, s, "refill", cfg)
+	mustDeny(t, s, "refill", cfg)
+}
+
+func testRefillCapped(t *testing.T, newStore Factory) {
+	clock := newClock()
+	s := open(t, newStore, clock)
+	cfg := ratelimiter.BucketConfig{Capacity: 3, RefillRate: 3, Interval: time.Second}
+
+	mustAllow(t, s, "capped", cfg)
+	clock.Advance(time.Hour)
+
+	for range cfg.Capacity {
+		mustAllow(t, s, "capped", cfg)
+	}
+	mustDeny(t, s, "capped", cfg)
+}
+
+// testConfigChange checks that a bucket used with a new config keeps its
+// tokens, capped at the new capacity, instead of starting over.
+func testConfigChange(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+
+	mustAllow(t, s, "config", slowConfig(5))
+	if d := mustAllow(t, s, "config", slowConfig(2)); d.Remaining != 1 || d.Limit != 2 {
+		t.Fatalf("expected tokens capped at the smaller capacity, got %+v", d)
+	}
+	if d := mustAllow(t, s, "config", slowConfig(10)); d.Remaining != 0 || d.Limit != 10 {
+		t.Fatalf("expected tokens to carry over to the larger capacity, got %+v", d)
+	}
+	mustDeny(t, s, "config", slowConfig(10))
+}
+
+func testConcurrentSameKey(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	cfg := slowConfig(100)
+
+	var wg sync.WaitGroup
+	var allowed, failed atomic.Int64
+	for range 500 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := s.Allow("shared", cfg)
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			if d.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() != 0 {
+		t.Fatalf("%d concurrent requests returned errors", failed.Load())
+	}
+	if allowed.Load() != cfg.Capacity {
+		t.Fatalf("expected %d allowed, got %d", cfg.Capacity, allowed.Load())
+	}
+}
+
+func testConcurrentMultipleKeys(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	cfg := slowConfig(10)
+
+	const keys = 8
+	var wg sync.WaitGroup
+	allowed := make([]atomic.Int64, keys)
+	for i := range keys {
+		key := string(rune('a' + i))
+		for range 100 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := s.Allow(key, cfg)
+				if err == nil && d.Allowed {
+					allowed[i].Add(1)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := range keys {
+		if got := allowed[i].Load(); got != cfg.Capacity {
+			t.Fatalf("key %c: expected %d allowed, got %d", 'a'+i, cfg.Capacity, got)
+		}
+	}
+}
+
+func testKeyIsolation(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	cfg := slowConfig(1)
+
+	mustAllow(t, s, "user-a", cfg)
+	mustDeny(t, s, "user-a", cfg)
+
+	for _, key := range []string{"user-b", "user-a:", "User-a", "user-a "} {
+		mustAllow(t, s, key, cfg)
+	}
+	mustDeny(t, s, "user-a", cfg)
+}
+
+// testKeyLayout checks keys that could collide with a store's own naming,
+// e.g. hash tags and suffixes in a Redis Cluster key layout.
+func testKeyLayout(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	cfg := slowConfig(1)
+
+	keys := []string{"user", "{user}", "user}:list", "user}:ban", "{", "}", "{}", "a{b}c"}
+	for _, key := range keys {
+		mustAllow(t, s, key, cfg)
+	}
+	for _, key := range keys {
+		mustDeny(t, s, key, cfg)
+	}
+}
+
+func testCleanup(t *testing.T, newStore Factory) {
+	clock := newClock()
+	s := open(t, newStore, clock)
+	cfg := slowConfig(1)
+
+	mustAllow(t, s, "idle", cfg)
+	mustDeny(t, s, "idle", cfg)
+
+	clock.Advance(2 * idleTimeout)
+
+	mustAllow(t, s, "active", cfg)
+	mustDeny(t, s, "active", cfg)
+
+	if err := s.DeleteInactiveBuckets(clock.Now().Add(-idleTimeout)); err != nil {
+		t.Fatalf("DeleteInactiveBuckets: unexpected error: %v", err)
+	}
+
+	// The idle key must start over with a full bucket, the active one must not.
+	mustAllow(t, s, "idle", cfg)
+	mustDeny(t, s, "active", cfg)
+
+	if err := s.DeleteInactiveBuckets(clock.Now().Add(-idleTimeout)); err != nil {
+		t.Fatalf("DeleteInactiveBuckets on recent keys: unexpected error: %v", err)
+	}
+	mustDeny(t, s, "idle", cfg)
+}
+
+func testInvalidConfig(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+
+	cases := map[string]ratelimiter.BucketConfig{
+		"zero-capacity": {Capacity: 0, RefillRate: 1, Interval: time.Second},
+		"zero-refill":   {Capacity: 1, RefillRate: 0, Interval: time.Second},
+		"zero-interval": {Capacity: 1, RefillRate: 1, Interval: 0},
+		"negative":      {Capacity: -1, RefillRate: -1, Interval: -time.Second},
+	}
+	for name, cfg := range cases {
+		if _, err := s.Allow("invalid-"+name, cfg); err == nil {
+			t.Errorf("%s: expected error for config %+v", name, cfg)
+		}
+	}
+}
+
+func testBackendError(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+
+	d, err := s.Allow("broken", slowConfig(1))
+	if err == nil {
+		t.Fatal("expected backend error to be returned")
+	}
+	if d.Allowed {
+		t.Fatal("expected failed request not to be allowed")
+	}
+}
+
+func testClockSkew(t *testing.T, newStore Factory) {
+	clock := newClock()
+	s := open(t, newStore, clock)
+	cfg := ratelimiter.BucketConfig{Capacity: 2, RefillRate: 1, Interval: time.Second}
+	start := clock.Now()
+
+	mustAllow(t, s, "skew", cfg)
+	mustAllow(t, s, "skew", cfg)
+
+	// A clock stepping backwards must neither refill nor break the bucket.
+	clock.Advance(-time.Hour)
+	mustDeny(t, s, "skew", cfg)
+	mustDeny(t, s, "skew", cfg)
+
+	// Once time catches up, refills resume from the last known refill.
+	clock.Set(start.Add(time.Second))
+	mustAllow(t, s, "skew", cfg)
+	mustDeny(t, s, "skew", cfg)
+
+	// A key first seen while the clock is behind behaves normally.
+	clock.Advance(-time.Hour)
+	mustAllow(t, s, "skew-new", cfg)
+	mustAllow(t, s, "skew-new", cfg)
+	mustDeny(t, s, "skew-new", cfg)
+}
+
+// testState covers the optional ratelimiter.StateStore operations.
+func testState(t *testing.T, newStore Factory) {
+	s := open(t, newStore, newClock())
+	ss, ok := s.(ratelimiter.StateStore)
+	if !ok {
+		t.Skip("store does not implement ratelimiter.StateStore")
+	}
+	cfg := slowConfig(3)
+
+	peek := func(key string) ratelimiter.Decision {
+		t.Helper()
+		d, err := ss.Peek(key, cfg)
+		if err != nil {
+			t.Fatalf("Peek(%q): unexpected error: %v", key, err)
+		}
+		return d
+	}
+
+	if d := peek("unknown"); !d.Allowed || d.Remaining != cfg.Capacity || d.Limit != cfg.Capacity {
+		t.Fatalf("expected unknown key to peek as a full bucket, got %+v", d)
+	}
+
+	mustAllow(t, s, "peek", cfg)
+	mustAllow(t, s, "peek", cfg)
+	for range 3 {
+		if d := peek("peek"); !d.Allowed || d.Remaining != 1 {
+			t.Fatalf("expected peek to report 1 remaining without consuming, got %+v", d)
+		}
+	}
+	mustAllow(t, s, "peek", cfg)
+	if d := peek("peek"); d.Allowed || d.Remaining != 0 || d.RetryAfter <= 0 {
+		t.Fatalf("expected peek on empty bucket to report denial, got %+v", d)
+	}
+
+	if err := ss.Reset("peek"); err != nil {
+		t.Fatalf("Reset: unexpected error: %v", err)
+	}
+	if d := mustAllow(t, s, "peek", cfg); d.Remaining != cfg.Capacity-1 {
+		t.Fatalf("expected reset bucket to be full, got %+v", d)
+	}
+
+	if err := ss.Set("seeded", cfg, 1); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	mustAllow(t, s, "seeded", cfg)
+	mustDeny(t, s, "seeded", cfg)
+
+	if err := ss.Set("seeded", cfg, 100); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if d := peek("seeded"); d.Remaining != cfg.Capacity {
+		t.Fatalf("expected Set to clamp to capacity, got %+v", d)
+	}
+	if err := ss.Set("seeded", cfg, -5); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	mustDeny(t, s, "seeded", cfg)
+
+	if err := ss.Delete("seeded"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	if d := mustAllow(t, s, "seeded", cfg); d.Remaining != cfg.Capacity-1 {
+		t.Fatalf("expected deleted key to start over, got %+v", d)
+	}
+	if err := ss.Delete("never-seen"); err != nil {
+		t.Fatalf("Delete of unknown key: unexpected error: %v", err)
+	}
+}